@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiAuth rejects all requests without the configured bearer token
+func apiAuth(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		auth := c.GetHeader("Authorization")
+		given, found := strings.CutPrefix(auth, "Bearer ")
+		if !found || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			_log(c, "API request %v %v with invalid token", c.Request.Method, c.Request.URL.Path)
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		c.Next()
+	}
+}
+
+func apiError(c *gin.Context, status int, err error) {
+	c.JSON(status, gin.H{"error": err.Error()})
+}
+
+func getRollouts(c *gin.Context) {
+	rollouts.CheckTimeouts()
+	c.JSON(http.StatusOK, rollouts.List())
+}
+
+func postRollouts(c *gin.Context) {
+	var r rollout
+	err := c.BindJSON(&r)
+	if err != nil {
+		return
+	}
+
+	err = rollouts.Create(r)
+	if err != nil {
+		apiError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.Status(http.StatusCreated)
+}
+
+func postRolloutAction(c *gin.Context) {
+	err := rollouts.Modify(c.Params.ByName("name"), c.Params.ByName("action"))
+	if err != nil {
+		apiError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func getInventory(c *gin.Context) {
+	c.JSON(http.StatusOK, inventory.List())
+}
+
+// registerAPI adds the management API used by the command line client
+func registerAPI(router *gin.Engine, token string) {
+	api := router.Group("/api", apiAuth(token))
+
+	api.GET("/inventory", getInventory)
+	api.GET("/rollouts", getRollouts)
+	api.POST("/rollouts", postRollouts)
+	api.POST("/rollouts/:name/:action", postRolloutAction)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zam-haus/dlsir/internal/config"
+)
+
+type certListEntry struct {
+	Mac      string    `json:"mac"`
+	Subject  string    `json:"subject"`
+	Serial   string    `json:"serial"`
+	Expires  time.Time `json:"expires"`
+	DaysLeft int       `json:"days-left"`
+	Renew    bool      `json:"renew"`
+}
+
+func runCertImportCA(args []string) int {
+	if len(args) != 2 {
+		return certUsage()
+	}
+
+	ca, err := certStore.ImportCA(args[0], args[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Imported CA %v, valid until %v\n", ca.Subject, formatTime(ca.NotAfter))
+	fmt.Printf("Client certificates issued by another CA are renewed on the next contact of the phone\n")
+	return 0
+}
+
+func runCertList(args []string) int {
+	flags := flag.NewFlagSet("cert list", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the certificates as JSON")
+	_ = flags.Parse(args)
+
+	ca, err := certStore.CA()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "No CA imported: %v\n", err)
+		return 1
+	}
+
+	clients, err := certStore.List()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read certificates: %v\n", err)
+		return 1
+	}
+
+	renewBefore := defaultCertRenewBefore
+	if srvConf, err := config.GetConfigFile(confSrv); err == nil {
+		if entry, err := srvConf.GetEntry("cert-renew-days"); err == nil {
+			renewBefore, err = parseDays(entry)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				return 1
+			}
+		}
+	}
+
+	now := time.Now()
+	list := make([]certListEntry, 0, len(clients))
+	for _, client := range clients {
+		list = append(list, certListEntry{
+			Mac:      client.Mac,
+			Subject:  client.Cert.Subject.String(),
+			Serial:   fmt.Sprintf("%x", client.Cert.SerialNumber),
+			Expires:  client.Cert.NotAfter,
+			DaysLeft: int(client.Cert.NotAfter.Sub(now).Hours() / 24),
+			Renew:    client.NeedsRenewal(ca, renewBefore, now),
+		})
+	}
+
+	if *asJSON {
+		out, _ := json.MarshalIndent(list, "", "  ")
+		fmt.Println(string(out))
+		return 0
+	}
+
+	fmt.Printf("CA: %v, valid until %v\n\n", ca.Subject, formatTime(ca.NotAfter))
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "MAC\tSERIAL\tEXPIRES\tDAYS LEFT\tRENEW")
+	for _, entry := range list {
+		renew := "-"
+		if entry.Renew {
+			renew = "on next contact"
+		} else if entry.DaysLeft < int(renewBefore.Hours()/24) {
+			renew = "needs new CA"
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", entry.Mac, entry.Serial, formatTime(entry.Expires), entry.DaysLeft, renew)
+	}
+	w.Flush()
+
+	return 0
+}
+
+func certUsage() int {
+	fmt.Fprintf(os.Stderr, "Usage: %v cert <import-ca|list> ...\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  cert import-ca <cert.pem> <key.pem>\n")
+	fmt.Fprintf(os.Stderr, "  cert list [-json]\n")
+	return 2
+}
+
+func runCert(args []string) int {
+	if len(args) == 0 {
+		return certUsage()
+	}
+
+	switch args[0] {
+	case "import-ca":
+		return runCertImportCA(args[1:])
+	case "list":
+		return runCertList(args[1:])
+	default:
+		return certUsage()
+	}
+}
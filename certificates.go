@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zam-haus/dlsir/internal/certs"
+	"github.com/zam-haus/dlsir/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Names of file-name entries which deploy certificates of the certificate store
+const (
+	clientCertificateFile = generatedPrefix + "client-certificate"
+	caCertificateFile     = generatedPrefix + "ca-certificate"
+)
+
+const (
+	defaultCertValidity    = 365 * 24 * time.Hour
+	defaultCertRenewBefore = 30 * 24 * time.Hour
+)
+
+// CA and the client certificates issued to phones
+var certStore = certs.NewStore(stateDir + "/certs")
+
+var (
+	certValidity    = defaultCertValidity
+	certRenewBefore = defaultCertRenewBefore
+	// password of the PKCS#12 files (cert-pkcs12-password)
+	certPassword = ""
+)
+
+func parseDays(entry config.ConfigEntry) (time.Duration, error) {
+	days, err := strconv.Atoi(entry.Value)
+	if err != nil || days <= 0 {
+		return 0, fmt.Errorf("invalid %v '%v', expected a number of days", entry.Name, entry.Value)
+	}
+	return time.Duration(days) * 24 * time.Hour, nil
+}
+
+// configureCertificates reads cert-validity-days, cert-renew-days and cert-pkcs12-password from
+// the server config
+func configureCertificates(srvConf *config.ConfigFile) error {
+	if entry, err := srvConf.GetEntry("cert-validity-days"); err == nil {
+		certValidity, err = parseDays(entry)
+		if err != nil {
+			return err
+		}
+	}
+	if entry, err := srvConf.GetEntry("cert-renew-days"); err == nil {
+		certRenewBefore, err = parseDays(entry)
+		if err != nil {
+			return err
+		}
+	}
+	if certRenewBefore >= certValidity {
+		return fmt.Errorf("cert-renew-days must be less than cert-validity-days")
+	}
+	if entry, err := srvConf.GetEntry("cert-pkcs12-password"); err == nil {
+		certPassword = entry.Value
+	}
+
+	if ca, err := certStore.CA(); err == nil {
+		_log(nil, "Issuing client certificates with CA %v (valid until %v)", ca.Subject, ca.NotAfter.Format(time.DateOnly))
+		if time.Until(ca.NotAfter) < certRenewBefore {
+			_log(nil, "WARNING: CA certificate expires at %v", ca.NotAfter.Format(time.DateOnly))
+		}
+	}
+
+	return nil
+}
+
+// clientCertificate returns the PKCS#12 file of the phone's client certificate.
+// A certificate is issued if the phone has none yet, if it expires within cert-renew-days or
+// if it was issued by another CA.
+func clientCertificate(c *gin.Context, phone *phoneDesc) (string, error) {
+	ca, err := certStore.CA()
+	if err != nil {
+		return "", fmt.Errorf("no CA imported; use 'dlsir cert import-ca'")
+	}
+
+	client, err := certStore.Client(phone.Mac)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case client == nil:
+		_log(c, "Issuing client certificate for %v", phone.Mac)
+	case client.NeedsRenewal(ca, certRenewBefore, time.Now()):
+		_log(c, "Renewing client certificate for %v (expires at %v)", phone.Mac, client.Cert.NotAfter.Format(time.DateOnly))
+	default:
+		if time.Until(client.Cert.NotAfter) < certRenewBefore {
+			_log(c, "WARNING: Client certificate for %v expires with the CA at %v; import a new CA to renew it", phone.Mac, client.Cert.NotAfter.Format(time.DateOnly))
+		}
+		return client.PKCS12File, nil
+	}
+
+	client, err = certStore.Issue(phone.Mac, phone.Mac, certValidity, certPassword)
+	if err != nil {
+		return "", err
+	}
+	_log(c, "Issued client certificate %x for %v, valid until %v", client.Cert.SerialNumber, phone.Mac, client.Cert.NotAfter.Format(time.DateOnly))
+	return client.PKCS12File, nil
+}
+
+// certificateFile returns the name a certificate of the certificate store is served as and its path
+func certificateFile(c *gin.Context, phone *phoneDesc, name string) (string, string, error) {
+	switch name {
+	case clientCertificateFile:
+		path, err := clientCertificate(c, phone)
+		if err != nil {
+			return "", "", err
+		}
+		return fmt.Sprintf("client-%v.p12", strings.ReplaceAll(phone.Mac, ":", "")), path, nil
+	case caCertificateFile:
+		if _, err := certStore.CA(); err != nil {
+			return "", "", fmt.Errorf("no CA imported; use 'dlsir cert import-ca'")
+		}
+		return "dlsir-ca.pem", certStore.CAFile(), nil
+	}
+	return "", "", fmt.Errorf("unknown certificate %v", name)
+}
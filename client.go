@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/zam-haus/dlsir/internal/config"
+)
+
+// apiClient talks to the management API of a running DLSir server
+type apiClient struct {
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+// pinnedCertificate returns a TLS config only accepting the given certificate
+func pinnedCertificate(certFile string) (*tls.Config, error) {
+	content, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read file %v: %v", certFile, err)
+	}
+
+	block, _ := pem.Decode(content)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("%v does not contain a PEM certificate", certFile)
+	}
+
+	return &tls.Config{
+		// the server certificate is verified below; its name usually doesn't match the API URL
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 || !bytes.Equal(rawCerts[0], block.Bytes) {
+				return fmt.Errorf("server certificate does not match %v", certFile)
+			}
+			return nil
+		},
+	}, nil
+}
+
+// newAPIClient configures the client from the server config (api-url, api-token, tls-cert-file)
+func newAPIClient() (*apiClient, error) {
+	conf, err := config.GetConfigFile(confSrv)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := conf.GetEntry("api-token")
+	if err != nil {
+		return nil, fmt.Errorf("api-token is not configured in %v", confSrv)
+	}
+
+	certFile, err := conf.GetEntry("tls-cert-file")
+	if err != nil {
+		return nil, fmt.Errorf("tls-cert-file is not configured in %v", confSrv)
+	}
+
+	tlsConfig, err := pinnedCertificate(certFile.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	baseURL := ""
+	if url, err := conf.GetEntry("api-url"); err == nil {
+		baseURL = url.Value
+	} else if port, err := conf.GetEntry("listen-port"); err == nil {
+		baseURL = "https://localhost:" + port.Value
+	} else {
+		return nil, fmt.Errorf("neither api-url nor listen-port are configured in %v", confSrv)
+	}
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	return &apiClient{baseURL: baseURL, token: token.Value, client: client}, nil
+}
+
+// do sends a request with body (if not nil) encoded as JSON and decodes the response into result (if not nil)
+func (api *apiClient) do(method string, path string, body any, result any) error {
+	var reqBody io.Reader
+	if body != nil {
+		content, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(content)
+	}
+
+	req, err := http.NewRequest(method, api.baseURL+"/api"+path, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+api.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := api.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(content, &apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("%v", apiErr.Error)
+		}
+		return fmt.Errorf("server responded with %v", resp.Status)
+	}
+
+	if result != nil {
+		return json.Unmarshal(content, result)
+	}
+
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) int
+}
+
+var commands = []command{
+	{name: "import", usage: "import [-dry-run] [-delimiter ;] <file.csv>\n      create or update phone configs and managed-phones from a CSV file", run: runImport},
+	{name: "firmware", usage: "firmware <inspect|manifest> ...\n      show the contents of firmware images and check whether they can be deployed;\n      generate their integrity manifests", run: runFirmware},
+	{name: "inventory", usage: "inventory [-json] [-updates] [-files]\n      list all known phones and their software update and file deployment history", run: runInventory},
+	{name: "cert", usage: "cert <import-ca|list> ...\n      import the CA for client certificates of the phones and list the issued certificates", run: runCert},
+	{name: "rollout", usage: "rollout <list|create|advance|pause|resume|delete> ...\n      inspect and control staged firmware rollouts of the running server", run: runRollout},
+}
+
+func printUsage() {
+	fmt.Fprintf(os.Stderr, "Usage: %v [command]\n\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "Without a command, the provisioning server is started.\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %v\n", cmd.usage)
+	}
+}
+
+func runCommand(name string, args []string) int {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd.run(args)
+		}
+	}
+
+	if name != "help" && name != "-h" && name != "--help" {
+		fmt.Fprintf(os.Stderr, "Unknown command '%v'\n\n", name)
+	}
+	printUsage()
+	return 2
+}
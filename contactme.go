@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zam-haus/dlsir/internal/config"
+)
+
+const (
+	defaultContactConcurrency    = 10
+	defaultContactConnectTimeout = 5 * time.Second
+	defaultContactTimeout        = 15 * time.Second
+)
+
+var (
+	// number of ContactMe requests sent in parallel (contact-concurrency)
+	contactConcurrency = defaultContactConcurrency
+	// timeout of the TCP connect (contact-connect-timeout)
+	contactConnectTimeout = defaultContactConnectTimeout
+	// timeout of the whole request, including the connect (contact-timeout)
+	contactTimeout = defaultContactTimeout
+	// minimum delay between starting two ContactMe requests of a round (contact-pacing)
+	contactPacing time.Duration
+)
+
+// configureContactMe reads contact-concurrency, contact-connect-timeout, contact-timeout and
+// contact-pacing from the server config
+func configureContactMe(srvConf *config.ConfigFile) error {
+	if entry, err := srvConf.GetEntry("contact-concurrency"); err == nil {
+		concurrency, err := strconv.Atoi(entry.Value)
+		if err != nil || concurrency <= 0 {
+			return fmt.Errorf("invalid contact-concurrency '%v'", entry.Value)
+		}
+		contactConcurrency = concurrency
+	}
+
+	durations := []struct {
+		name     string
+		value    *time.Duration
+		positive bool
+	}{
+		{"contact-connect-timeout", &contactConnectTimeout, true},
+		{"contact-timeout", &contactTimeout, true},
+		{"contact-pacing", &contactPacing, false},
+	}
+	for _, d := range durations {
+		entry, err := srvConf.GetEntry(d.name)
+		if err != nil {
+			continue
+		}
+		value, err := time.ParseDuration(entry.Value)
+		if err != nil || value < 0 || (d.positive && value == 0) {
+			return fmt.Errorf("invalid %v '%v'", d.name, entry.Value)
+		}
+		*d.value = value
+	}
+
+	if contactConnectTimeout > contactTimeout {
+		contactConnectTimeout = contactTimeout
+	}
+
+	return nil
+}
+
+type connDialer struct {
+	c net.Conn
+}
+
+func (cd connDialer) Dial(network, addr string) (net.Conn, error) {
+	return cd.c, nil
+}
+
+var errContactMeRejected = errors.New("ContactMe rejected")
+
+// contactMe sends a ContactMe in contactPhones; replaced in tests
+var contactMe = sendContactMe
+
+// sendContactMe asks the phone at host to contact DLSir; the result is logged
+func sendContactMe(listenPort, host string) error {
+	deadline := time.Now().Add(contactTimeout)
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, "8085"), contactConnectTimeout)
+	if err != nil {
+		_log(nil, "Connection to %v failed - %v", host, err)
+		return err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(deadline)
+
+	connIP, _, err := net.SplitHostPort(conn.LocalAddr().String())
+	if err != nil {
+		_log(nil, "Failed to parse local addr - this should not happen: %v", err)
+		return err
+	}
+
+	_log(nil, "Sending ContactMe to %v; %v:%v\n", host, connIP, listenPort)
+
+	client := http.Client{Transport: &http.Transport{Dial: connDialer{conn}.Dial}, Timeout: time.Until(deadline)}
+	url := fmt.Sprintf("http://%v:8085/contact_dls.html/ContactDLS", host)
+	body := strings.NewReader(fmt.Sprintf("ContactMe=true&dls_ip_addr=%v&dls_ip_port=%v", connIP, listenPort))
+
+	response, err := client.Post(url, "application/x-www-form-urlencoded", body)
+	if err != nil {
+		_log(nil, "ContactMe for %v failed: %v", host, err)
+		return err
+	}
+	response.Body.Close()
+
+	if response.StatusCode != 204 {
+		_log(nil, "Unexpected response from %v for ContactMe: %v", host, response.Status)
+		return fmt.Errorf("%w: %v", errContactMeRejected, response.Status)
+	}
+
+	_log(nil, "ContactMe successfully sent to %v\n", host)
+	return nil
+}
+
+// contactStats summarizes a round of ContactMe requests
+type contactStats struct {
+	Total     int
+	Succeeded int
+	TimedOut  int
+	Refused   int // connection failed for other reasons than a timeout
+	Rejected  int // the phone answered with an error
+	Duration  time.Duration
+}
+
+func (stats contactStats) String() string {
+	return fmt.Sprintf("%v of %v phones reached in %v (%v timed out, %v unreachable, %v rejected)",
+		stats.Succeeded, stats.Total, stats.Duration.Round(time.Millisecond), stats.TimedOut, stats.Refused, stats.Rejected)
+}
+
+func (stats *contactStats) add(err error) {
+	var netErr net.Error
+	switch {
+	case err == nil:
+		stats.Succeeded++
+	case errors.Is(err, errContactMeRejected):
+		stats.Rejected++
+	case errors.As(err, &netErr) && netErr.Timeout():
+		stats.TimedOut++
+	default:
+		stats.Refused++
+	}
+}
+
+// contactPhones sends a ContactMe to all hosts with up to contact-concurrency requests in
+// parallel, starting them at least contact-pacing apart. done is called with the result of every host.
+func contactPhones(listenPort string, hosts []string, done func(host string, err error)) contactStats {
+	start := time.Now()
+	stats := contactStats{Total: len(hosts)}
+	if len(hosts) == 0 {
+		return stats
+	}
+
+	type result struct {
+		host string
+		err  error
+	}
+
+	jobs := make(chan string)
+	results := make(chan result)
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < min(contactConcurrency, len(hosts)); worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for host := range jobs {
+				results <- result{host, contactMe(listenPort, host)}
+			}
+		}()
+	}
+
+	go func() {
+		for idx, host := range hosts {
+			if idx > 0 && contactPacing > 0 {
+				time.Sleep(contactPacing)
+			}
+			jobs <- host
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	for res := range results {
+		stats.add(res.err)
+		if done != nil {
+			done(res.host, res.err)
+		}
+	}
+
+	stats.Duration = time.Since(start)
+	return stats
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// withContactMe runs the test with send replacing sendContactMe and the given settings
+func withContactMe(t *testing.T, concurrency int, pacing time.Duration, send func(listenPort, host string) error) {
+	t.Helper()
+
+	oldSend, oldConcurrency, oldPacing := contactMe, contactConcurrency, contactPacing
+	contactMe, contactConcurrency, contactPacing = send, concurrency, pacing
+	t.Cleanup(func() { contactMe, contactConcurrency, contactPacing = oldSend, oldConcurrency, oldPacing })
+}
+
+func hostNames(n int) []string {
+	hosts := make([]string, n)
+	for idx := range hosts {
+		hosts[idx] = fmt.Sprintf("phone%v", idx)
+	}
+	return hosts
+}
+
+func TestContactPhonesConcurrency(t *testing.T) {
+	var mu sync.Mutex
+	active, maxActive := 0, 0
+	withContactMe(t, 3, 0, func(listenPort, host string) error {
+		mu.Lock()
+		active++
+		maxActive = max(maxActive, active)
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		active--
+		mu.Unlock()
+		return nil
+	})
+
+	hosts := hostNames(10)
+	done := make([]string, 0)
+	stats := contactPhones("18443", hosts, func(host string, err error) {
+		done = append(done, host)
+	})
+
+	if maxActive != 3 {
+		t.Errorf("%v ContactMe requests in parallel, want 3", maxActive)
+	}
+	slices.Sort(done)
+	if !slices.Equal(done, hosts) {
+		t.Errorf("done called for %v, want %v", done, hosts)
+	}
+	if stats.Total != 10 || stats.Succeeded != 10 {
+		t.Errorf("stats = %+v, want 10 of 10 succeeded", stats)
+	}
+	// 4 batches of 20 ms
+	if stats.Duration < 80*time.Millisecond {
+		t.Errorf("Duration = %v, want at least 80ms", stats.Duration)
+	}
+}
+
+func TestContactPhonesPacing(t *testing.T) {
+	var mu sync.Mutex
+	starts := make([]time.Time, 0)
+	withContactMe(t, 10, 30*time.Millisecond, func(listenPort, host string) error {
+		mu.Lock()
+		starts = append(starts, time.Now())
+		mu.Unlock()
+		return nil
+	})
+
+	stats := contactPhones("18443", hostNames(4), nil)
+
+	if len(starts) != 4 || stats.Succeeded != 4 {
+		t.Fatalf("%v ContactMe requests sent, stats %+v, want 4", len(starts), stats)
+	}
+	for idx := 1; idx < len(starts); idx++ {
+		// allow for the timer resolution
+		if gap := starts[idx].Sub(starts[idx-1]); gap < 25*time.Millisecond {
+			t.Errorf("request %v started %v after the previous one, want at least 30ms", idx, gap)
+		}
+	}
+}
+
+func TestContactPhonesStats(t *testing.T) {
+	results := map[string]error{
+		"ok1":      nil,
+		"ok2":      nil,
+		"timeout":  &net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded},
+		"refused":  &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED},
+		"noroute":  &net.OpError{Op: "dial", Net: "tcp", Err: syscall.EHOSTUNREACH},
+		"rejected": fmt.Errorf("%w: %v", errContactMeRejected, "500 Internal Server Error"),
+	}
+	withContactMe(t, 2, 0, func(listenPort, host string) error {
+		return results[host]
+	})
+
+	hosts := make([]string, 0)
+	for host := range results {
+		hosts = append(hosts, host)
+	}
+
+	var mu sync.Mutex
+	got := make(map[string]error)
+	stats := contactPhones("18443", hosts, func(host string, err error) {
+		mu.Lock()
+		got[host] = err
+		mu.Unlock()
+	})
+
+	want := contactStats{Total: 6, Succeeded: 2, TimedOut: 1, Refused: 2, Rejected: 1}
+	stats.Duration = 0
+	if stats != want {
+		t.Errorf("stats = %+v, want %+v", stats, want)
+	}
+	for host, err := range results {
+		if got[host] != err {
+			t.Errorf("done(%v) = %v, want %v", host, got[host], err)
+		}
+	}
+
+	if stats := contactPhones("18443", nil, nil); stats.Total != 0 || stats.Succeeded != 0 {
+		t.Errorf("stats of no hosts = %+v", stats)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/zam-haus/dlsir/internal/atomicfile"
+	"github.com/zam-haus/dlsir/internal/bitmap"
+	"github.com/zam-haus/dlsir/internal/config"
+	"github.com/zam-haus/dlsir/internal/firmware"
+	"github.com/zam-haus/dlsir/internal/media"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Converted files, by device type
+const cacheDir = "./cache/"
+
+// Image kinds (see generators) by file-type
+var imageFileTypes = map[string]string{
+	"LOGO":        "logo",
+	"SCREENSAVER": "screensaver",
+}
+
+// Audio format accepted by the phones, keyed by GetDeviceKey(device-type)
+var audioFormats = map[string]media.AudioFormat{
+	"openstage15": {SampleRate: 8000, Bits: 16, Channels: 1},
+	"openstage40": {SampleRate: 8000, Bits: 16, Channels: 1},
+	"openstage60": {SampleRate: 16000, Bits: 16, Channels: 1},
+	"openstage80": {SampleRate: 16000, Bits: 16, Channels: 1},
+}
+
+var defaultAudioFormat = media.AudioFormat{SampleRate: 8000, Bits: 16, Channels: 1}
+
+// isFresh returns whether the converted file exists and is newer than its source
+func isFresh(converted string, source os.FileInfo) bool {
+	fi, err := os.Stat(converted)
+	return err == nil && !fi.ModTime().Before(source.ModTime())
+}
+
+// convertFile validates a file of files/ for the phone and converts it to the format of the
+// device type if required: PNG images to BMP in the resolution of the file-type, WAV files to
+// the supported audio format. Conversions are cached in cache/<device type>/.
+// Returns the name the file is served as and its path.
+func convertFile(c *gin.Context, name string, fileType string, phone *phoneDesc, conf *config.ConfigFile) (string, string, error) {
+	path, err := confinedPath(name)
+	if err != nil {
+		return "", "", err
+	}
+
+	source, err := os.Stat(path)
+	if err != nil {
+		return "", "", err
+	}
+
+	devKey := firmware.DeviceKey(phone.DevType)
+	stem := strings.TrimSuffix(name, filepath.Ext(name))
+
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".png":
+		kind, ok := imageFileTypes[fileType]
+		if !ok {
+			return "", "", fmt.Errorf("%v is an image, but file-type %v isn't", name, fileType)
+		}
+		size, err := imageSizeFor(kind, phone, conf)
+		if err != nil {
+			return "", "", err
+		}
+		background, err := bitmap.ParseColor(imageOption(conf, kind, "background", "ffffff"))
+		if err != nil {
+			return "", "", err
+		}
+
+		converted := filepath.Join(cacheDir, devKey, fmt.Sprintf("%v-%v-%v.bmp", stem, size, background))
+		if !isFresh(converted, source) {
+			_log(c, "Converting %v to %v BMP for %v", name, size, phone.DevType)
+			err = convertImage(path, converted, size, background)
+			if err != nil {
+				return "", "", fmt.Errorf("failed to convert %v: %v", name, err)
+			}
+		}
+		return fmt.Sprintf("%v-%v.bmp", stem, devKey), converted, nil
+
+	case ".bmp":
+		kind, ok := imageFileTypes[fileType]
+		if !ok {
+			return name, path, nil
+		}
+		size, err := imageSizeFor(kind, phone, conf)
+		if err != nil {
+			return name, path, nil
+		}
+		err = checkBMPSize(path, size)
+		if err != nil {
+			// the size table is a best guess; deploy it anyway
+			_log(c, "WARNING: %v: %v", name, err)
+		}
+		return name, path, nil
+
+	case ".wav":
+		format, ok := audioFormats[devKey]
+		if !ok {
+			format = defaultAudioFormat
+		}
+
+		converted := filepath.Join(cacheDir, devKey, fmt.Sprintf("%v-%v-%v.wav", stem, format.SampleRate, format.Bits))
+		if isFresh(converted, source) {
+			return fmt.Sprintf("%v-%v.wav", stem, devKey), converted, nil
+		}
+
+		audio, srcFormat, err := decodeWAVFile(path)
+		if err != nil {
+			return "", "", fmt.Errorf("%v: %v", name, err)
+		}
+		if srcFormat == format {
+			return name, path, nil
+		}
+
+		_log(c, "Converting %v from %v to %v for %v", name, srcFormat, format, phone.DevType)
+		var buf bytes.Buffer
+		err = audio.Resample(format.SampleRate).EncodeWAV(&buf, format.Bits)
+		if err == nil {
+			err = atomicfile.WriteFile(converted, buf.Bytes(), 0644)
+		}
+		if err != nil {
+			return "", "", fmt.Errorf("failed to convert %v: %v", name, err)
+		}
+		return fmt.Sprintf("%v-%v.wav", stem, devKey), converted, nil
+	}
+
+	return name, path, nil
+}
+
+func convertImage(path string, converted string, size imageSize, background bitmap.Color) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	img, err := media.ConvertImage(f, size.Width, size.Height, background)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	err = img.EncodeBMP(&buf)
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(converted, buf.Bytes(), 0644)
+}
+
+func checkBMPSize(path string, size imageSize) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	width, height, err := media.BMPSize(f)
+	if err != nil {
+		return err
+	}
+	if width != size.Width || height != size.Height {
+		return fmt.Errorf("image has %vx%v pixels, the phone expects %v", width, height, size)
+	}
+	return nil
+}
+
+func decodeWAVFile(path string) (*media.Audio, media.AudioFormat, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, media.AudioFormat{}, err
+	}
+	defer f.Close()
+
+	return media.DecodeWAV(f)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/zam-haus/dlsir/internal/config"
+	"github.com/zam-haus/dlsir/internal/sipcreds"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Selects the registrar endpoint of a phone; defaults to sip-user-id or e164
+const sipEndpointEntry = config.LocalPrefix + "sip-endpoint"
+
+// sipEndpointName returns the name of the registrar endpoint for the given phone config
+func sipEndpointName(conf *config.ConfigFile) string {
+	for _, name := range []string{sipEndpointEntry, "sip-user-id", "e164"} {
+		entry, err := conf.GetEntry(name)
+		if err == nil && entry.Value != "" {
+			return entry.Value
+		}
+	}
+	return ""
+}
+
+// applySipCredentials overrides the SIP credentials in conf with the ones from the
+// registrar configured as sip-credentials in the server config (if any)
+func applySipCredentials(c *gin.Context, srvConf *config.ConfigFile, conf *config.ConfigFile) error {
+	source, err := srvConf.GetEntry("sip-credentials")
+	if err != nil {
+		// registrar import not configured; use the credentials from the phone config
+		return nil
+	}
+
+	creds, warnings, err := sipcreds.Load(source.Value)
+	if err != nil {
+		return err
+	}
+	for _, warning := range warnings {
+		_log(c, "WARNING: %v: %v", source.Value, warning)
+	}
+
+	endpoint := sipEndpointName(conf)
+	cred, ok := creds[endpoint]
+	if !ok {
+		return fmt.Errorf("endpoint '%v' not found in %v", endpoint, source.Value)
+	}
+
+	conf.SetEntries([]config.ConfigEntry{
+		{Name: "sip-user-id", Value: cred.Username},
+		{Name: "sip-pwd", Value: cred.Password},
+		{Name: "e164", Value: cred.Extension},
+	})
+
+	return nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a cron expression "minute hour day-of-month month day-of-week", optionally
+// followed by a time zone, e.g. "30 2 * * 1-5 Europe/Berlin". Fields are *, numbers, ranges
+// (1-5), steps (*/15, 0-30/10) and lists of them (1,15). Day of week 0 and 7 are Sunday.
+// As in cron, a day matches day-of-month or day-of-week if both are restricted, and times
+// skipped by a DST change run right after it.
+type cronSchedule struct {
+	Text   string
+	minute [60]bool
+	hour   [24]bool
+	dom    [32]bool
+	month  [13]bool
+	dow    [7]bool
+	// whether day-of-month or day-of-week is *
+	domAny bool
+	dowAny bool
+	loc    *time.Location
+}
+
+// parseCronField sets the values of the field in set (indexed by value)
+func parseCronField(text string, min int, max int, set []bool) error {
+	for _, part := range strings.Split(text, ",") {
+		rangeText, stepText, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepText)
+			if err != nil || step <= 0 {
+				return fmt.Errorf("invalid step '%v'", stepText)
+			}
+		}
+
+		first, last := min, max
+		if rangeText != "*" {
+			firstText, lastText, isRange := strings.Cut(rangeText, "-")
+
+			var err error
+			first, err = strconv.Atoi(firstText)
+			if err != nil {
+				return fmt.Errorf("invalid value '%v'", firstText)
+			}
+			last = first
+			if isRange {
+				last, err = strconv.Atoi(lastText)
+				if err != nil {
+					return fmt.Errorf("invalid value '%v'", lastText)
+				}
+			} else if hasStep {
+				// "5/15" is 5, 20, 35, 50
+				last = max
+			}
+		}
+
+		if first < min || last > max || first > last {
+			return fmt.Errorf("'%v' is out of range %v-%v", part, min, max)
+		}
+		for value := first; value <= last; value += step {
+			set[value] = true
+		}
+	}
+	return nil
+}
+
+func parseCronSchedule(text string) (*cronSchedule, error) {
+	fields := strings.Fields(text)
+	if len(fields) != 5 && len(fields) != 6 {
+		return nil, fmt.Errorf("invalid cron expression '%v', expected minute hour day-of-month month day-of-week [time zone]", text)
+	}
+
+	s := &cronSchedule{Text: text, domAny: fields[2] == "*", dowAny: fields[4] == "*", loc: time.Local}
+
+	var dow [8]bool
+	specs := []struct {
+		name     string
+		min, max int
+		set      []bool
+	}{
+		{"minute", 0, 59, s.minute[:]},
+		{"hour", 0, 23, s.hour[:]},
+		{"day of month", 1, 31, s.dom[:]},
+		{"month", 1, 12, s.month[:]},
+		{"day of week", 0, 7, dow[:]},
+	}
+	for idx, spec := range specs {
+		err := parseCronField(fields[idx], spec.min, spec.max, spec.set)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %v in '%v': %v", spec.name, text, err)
+		}
+	}
+	copy(s.dow[:], dow[:7])
+	s.dow[0] = s.dow[0] || dow[7]
+
+	if len(fields) == 6 {
+		loc, err := time.LoadLocation(fields[5])
+		if err != nil {
+			return nil, fmt.Errorf("unknown time zone '%v': %v", fields[5], err)
+		}
+		s.loc = loc
+	}
+
+	if s.Next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("cron expression '%v' never matches", text)
+	}
+
+	return s, nil
+}
+
+func (s *cronSchedule) String() string {
+	return s.Text
+}
+
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom[t.Day()]
+	dow := s.dow[t.Weekday()]
+
+	switch {
+	case s.domAny:
+		return dow
+	case s.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// Next returns the first matching minute after t; the zero time if there is none within 5 years
+func (s *cronSchedule) Next(t time.Time) time.Time {
+	t = t.In(s.loc).Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		switch {
+		case !s.month[t.Month()]:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, s.loc)
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, s.loc)
+		case !s.hour[t.Hour()]:
+			hour := t.Hour() + 1
+			t = time.Date(t.Year(), t.Month(), t.Day(), hour, 0, 0, 0, s.loc)
+			if hour < 24 && t.Hour() != hour && s.hour[hour] {
+				// the hour was skipped by a DST change; as cron does, run right after it
+				return t
+			}
+		case !s.minute[t.Minute()]:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
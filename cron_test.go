@@ -0,0 +1,158 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseCronField(t *testing.T) {
+	tests := []struct {
+		text string
+		min  int
+		max  int
+		want []int
+	}{
+		{"*", 0, 5, []int{0, 1, 2, 3, 4, 5}},
+		{"3", 0, 59, []int{3}},
+		{"1-4", 0, 59, []int{1, 2, 3, 4}},
+		{"*/15", 0, 59, []int{0, 15, 30, 45}},
+		{"5/20", 0, 59, []int{5, 25, 45}},
+		{"0-30/10", 0, 59, []int{0, 10, 20, 30}},
+		{"1,15,20-21", 1, 31, []int{1, 15, 20, 21}},
+		{"*/5", 1, 12, []int{1, 6, 11}},
+		{"7", 0, 7, []int{7}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			set := make([]bool, tt.max+1)
+			err := parseCronField(tt.text, tt.min, tt.max, set)
+			if err != nil {
+				t.Fatalf("parseCronField(%q): %v", tt.text, err)
+			}
+
+			got := make([]int, 0)
+			for value, ok := range set {
+				if ok {
+					got = append(got, value)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("parseCronField(%q) = %v, want %v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCronFieldInvalid(t *testing.T) {
+	for _, text := range []string{"", "x", "60", "5-1", "*/0", "*/x", "1-", "-1", "1,,2"} {
+		t.Run(text, func(t *testing.T) {
+			set := make([]bool, 60)
+			if err := parseCronField(text, 0, 59, set); err == nil {
+				t.Errorf("parseCronField(%q) accepted an invalid field", text)
+			}
+		})
+	}
+}
+
+func TestParseCronScheduleInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"* * * *",
+		"* * * * * * *",
+		"0 24 * * *",
+		"0 0 0 * *",
+		"0 0 * 13 *",
+		"0 0 * * 8",
+		"0 0 * * * Europe/Atlantis",
+		// February 31st never happens
+		"0 0 31 2 *",
+	}
+
+	for _, text := range tests {
+		t.Run(text, func(t *testing.T) {
+			if _, err := parseCronSchedule(text); err == nil {
+				t.Errorf("parseCronSchedule(%q) accepted an invalid expression", text)
+			}
+		})
+	}
+}
+
+func TestCronScheduleNext(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(year int, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, berlin)
+	}
+
+	// 2024-05-01 is a Wednesday
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want []time.Time
+	}{
+		{"every minute", "* * * * *", at(2024, 5, 1, 10, 0).Add(30 * time.Second),
+			[]time.Time{at(2024, 5, 1, 10, 1), at(2024, 5, 1, 10, 2)}},
+		{"daily", "0 3 * * *", at(2024, 5, 1, 3, 0),
+			[]time.Time{at(2024, 5, 2, 3, 0), at(2024, 5, 3, 3, 0)}},
+		{"steps", "*/20 8-9 * * *", at(2024, 5, 1, 8, 50),
+			[]time.Time{at(2024, 5, 1, 9, 0), at(2024, 5, 1, 9, 20), at(2024, 5, 1, 9, 40), at(2024, 5, 2, 8, 0)}},
+		{"weekdays", "30 2 * * 1-5", at(2024, 5, 3, 12, 0),
+			[]time.Time{at(2024, 5, 6, 2, 30), at(2024, 5, 7, 2, 30)}},
+		{"7 is Sunday", "0 0 * * 7", at(2024, 5, 1, 0, 0),
+			[]time.Time{at(2024, 5, 5, 0, 0), at(2024, 5, 12, 0, 0)}},
+		{"0 is Sunday", "0 0 * * 0", at(2024, 5, 1, 0, 0),
+			[]time.Time{at(2024, 5, 5, 0, 0), at(2024, 5, 12, 0, 0)}},
+		// day of month or day of week if both are restricted
+		{"dom or dow", "0 0 13 * 5", at(2024, 9, 1, 0, 0),
+			[]time.Time{at(2024, 9, 6, 0, 0), at(2024, 9, 13, 0, 0), at(2024, 9, 20, 0, 0)}},
+		{"dom only", "0 0 13 * *", at(2024, 9, 1, 0, 0),
+			[]time.Time{at(2024, 9, 13, 0, 0), at(2024, 10, 13, 0, 0)}},
+		{"month", "0 0 1 2,8 *", at(2024, 5, 1, 0, 0),
+			[]time.Time{at(2024, 8, 1, 0, 0), at(2025, 2, 1, 0, 0)}},
+		{"leap day", "0 12 29 2 *", at(2024, 3, 1, 0, 0),
+			[]time.Time{at(2028, 2, 29, 12, 0)}},
+		// 2024-03-31 02:00 CET is 03:00 CEST
+		{"dst start keeps wall clock", "0 12 * * *", at(2024, 3, 30, 13, 0),
+			[]time.Time{at(2024, 3, 31, 12, 0), at(2024, 4, 1, 12, 0)}},
+		{"dst start runs skipped time after the change", "30 2 * * *", at(2024, 3, 30, 23, 0),
+			[]time.Time{at(2024, 3, 31, 3, 0), at(2024, 4, 1, 2, 30)}},
+		// 2024-10-27 03:00 CEST is 02:00 CET; 02:30 only runs once
+		{"dst end", "30 2 * * *", at(2024, 10, 26, 23, 0),
+			[]time.Time{time.Date(2024, 10, 27, 1, 30, 0, 0, time.UTC), at(2024, 10, 28, 2, 30)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := parseCronSchedule(tt.expr + " Europe/Berlin")
+			if err != nil {
+				t.Fatalf("parseCronSchedule(%q): %v", tt.expr, err)
+			}
+
+			next := tt.from
+			for _, want := range tt.want {
+				next = s.Next(next)
+				if !next.Equal(want) {
+					t.Fatalf("Next = %v, want %v", next, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCronScheduleTimeZone(t *testing.T) {
+	s, err := parseCronSchedule("0 3 * * * America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 03:00 EDT is 07:00 UTC
+	next := s.Next(time.Date(2024, time.May, 1, 12, 0, 0, 0, time.UTC))
+	if want := time.Date(2024, time.May, 2, 7, 0, 0, 0, time.UTC); !next.Equal(want) {
+		t.Errorf("Next = %v, want %v", next, want)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zam-haus/dlsir/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Default time a phone may hold a deployment slot before it is given to the next phone
+const defaultDeploymentTimeout = 30 * time.Minute
+
+type queuedPhone struct {
+	Mac string
+	IP  string
+}
+
+// deploymentLimiter caps the number of simultaneous software deployments.
+// Phones over the cap are queued and contacted again once a slot frees up;
+// the freed slot is reserved for them until they come back (or the timeout elapses).
+type deploymentLimiter struct {
+	mu      sync.Mutex
+	max     int // 0: unlimited
+	timeout time.Duration
+	// slot holders by MAC address, with the time their slot expires
+	active map[string]time.Time
+	queue  []queuedPhone
+}
+
+var deployments = &deploymentLimiter{timeout: defaultDeploymentTimeout, active: make(map[string]time.Time)}
+
+// configure reads max-concurrent-deployments and deployment-timeout from the server config
+func (limiter *deploymentLimiter) configure(srvConf *config.ConfigFile) error {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	if entry, err := srvConf.GetEntry("max-concurrent-deployments"); err == nil {
+		max, err := strconv.Atoi(entry.Value)
+		if err != nil || max < 0 {
+			return fmt.Errorf("invalid max-concurrent-deployments '%v'", entry.Value)
+		}
+		limiter.max = max
+	}
+
+	if entry, err := srvConf.GetEntry("deployment-timeout"); err == nil {
+		timeout, err := time.ParseDuration(entry.Value)
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("invalid deployment-timeout '%v'", entry.Value)
+		}
+		limiter.timeout = timeout
+	}
+
+	return nil
+}
+
+// Acquire returns whether the phone may start a software deployment now.
+// Otherwise, the phone is queued and contacted again once a slot is available.
+func (limiter *deploymentLimiter) Acquire(phone *phoneDesc) bool {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	limiter.expire(time.Now())
+
+	_, holdsSlot := limiter.active[phone.Mac]
+	if holdsSlot || limiter.max == 0 || len(limiter.active) < limiter.max {
+		limiter.active[phone.Mac] = time.Now().Add(limiter.timeout)
+		limiter.queue = slices.DeleteFunc(limiter.queue, func(q queuedPhone) bool { return q.Mac == phone.Mac })
+		return true
+	}
+
+	if !slices.ContainsFunc(limiter.queue, func(q queuedPhone) bool { return q.Mac == phone.Mac }) {
+		limiter.queue = append(limiter.queue, queuedPhone{Mac: phone.Mac, IP: phone.IP})
+	}
+	return false
+}
+
+// Release frees the slot of the phone (if it holds one)
+func (limiter *deploymentLimiter) Release(phone *phoneDesc) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	if _, ok := limiter.active[phone.Mac]; !ok {
+		return
+	}
+
+	delete(limiter.active, phone.Mac)
+	limiter.next()
+}
+
+// Status returns the number of active deployments and queued phones
+func (limiter *deploymentLimiter) Status() (int, int) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	return len(limiter.active), len(limiter.queue)
+}
+
+// expire frees all slots whose phones didn't come back in time; must be called with limiter.mu held
+func (limiter *deploymentLimiter) expire(now time.Time) {
+	for mac, deadline := range limiter.active {
+		if now.After(deadline) {
+			_log(nil, "Deployment slot of %v expired", mac)
+			delete(limiter.active, mac)
+			limiter.next()
+		}
+	}
+}
+
+// next reserves free slots for queued phones and contacts them; must be called with limiter.mu held
+func (limiter *deploymentLimiter) next() {
+	for len(limiter.queue) > 0 && (limiter.max == 0 || len(limiter.active) < limiter.max) {
+		q := limiter.queue[0]
+		limiter.queue = limiter.queue[1:]
+		limiter.active[q.Mac] = time.Now().Add(limiter.timeout)
+
+		_log(nil, "Deployment slot available; contacting queued phone %v / %v", q.Mac, q.IP)
+		go sendContactMe(listenPort, q.IP)
+	}
+}
+
+// deploymentTimerFunc regularly frees expired deployment slots
+func deploymentTimerFunc() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		deployments.mu.Lock()
+		deployments.expire(time.Now())
+		deployments.mu.Unlock()
+	}
+}
+
+// Download bandwidth per connection in bytes per second (file-rate-limit); 0: unlimited
+var fileRateLimit int64
+
+// parseByteRate parses rates like "500k" or "2M" (bytes per second)
+func parseByteRate(text string) (int64, error) {
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(text, "k"):
+		multiplier = 1024
+	case strings.HasSuffix(text, "M"):
+		multiplier = 1024 * 1024
+	}
+
+	rate, err := strconv.ParseInt(strings.TrimRight(text, "kM"), 10, 64)
+	if err != nil || rate < 0 {
+		return 0, fmt.Errorf("invalid rate '%v', expected bytes per second like 500k or 2M", text)
+	}
+	return rate * multiplier, nil
+}
+
+// throttledWriter limits the rate at which a response is written
+type throttledWriter struct {
+	gin.ResponseWriter
+	rate    int64
+	start   time.Time
+	written int64
+}
+
+func newThrottledWriter(w gin.ResponseWriter, rate int64) *throttledWriter {
+	return &throttledWriter{ResponseWriter: w, rate: rate, start: time.Now()}
+}
+
+func (w *throttledWriter) Write(data []byte) (int, error) {
+	// write in chunks of 1/10 s so the rate is even for large writes
+	chunkSize := max(int(w.rate/10), 1024)
+
+	total := 0
+	for len(data) > 0 {
+		chunk := min(len(data), chunkSize)
+		n, err := w.ResponseWriter.Write(data[:chunk])
+		total += n
+		w.written += int64(n)
+		if err != nil {
+			return total, err
+		}
+		data = data[chunk:]
+
+		due := w.start.Add(time.Duration(float64(w.written) / float64(w.rate) * float64(time.Second)))
+		time.Sleep(time.Until(due))
+	}
+
+	return total, nil
+}
+
+func (w *throttledWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
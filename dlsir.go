@@ -2,12 +2,12 @@ package main
 
 import (
 	"fmt"
-	"net"
 	"net/http"
 	"os"
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/zam-haus/dlsir/internal/config"
@@ -60,11 +60,24 @@ type phoneDesc struct {
 	IP            string
 	Number        string
 	NextStep      phoneNextProvStep
-	PendingFiles  []string
+	PendingFiles  []string // files the phone may download in its current deployment
+	Generated     map[string]*generatedFile
+	Converted     map[string]string // paths of the deployed files, by the name they are served as
 	RqBegin       time.Time
 	DevType       string
+	FwType        string
 	FwVersion     firmware.FirmwareVersion
+	FwTarget      *firmware.FirmwareInfo // next image to deploy; an intermediate hop on multi-hop upgrades
+	FwFinal       *firmware.FirmwareInfo // image the phone should eventually run
 	FwNeedsUpdate bool
+	FileRetries   int // FileDeployments repeated because of failed files
+
+	// held while a request of the phone is handled; guards all fields above
+	mu sync.Mutex
+}
+
+func (phone *phoneDesc) device() config.Device {
+	return config.Device{Type: phone.DevType, FwVersion: phone.FwVersion}
 }
 
 type item struct {
@@ -98,7 +111,45 @@ type dlsMessage struct {
 	Message message  `xml:"Message"`
 }
 
-var phoneState map[string]*phoneDesc
+// provisioning sessions by phone IP; requests of different phones are handled in parallel
+var phoneState = struct {
+	sync.Mutex
+	phones map[string]*phoneDesc
+}{phones: make(map[string]*phoneDesc)}
+
+// lookupPhone returns the provisioning session of the phone at ip; nil if there is none
+func lookupPhone(ip string) *phoneDesc {
+	phoneState.Lock()
+	defer phoneState.Unlock()
+
+	return phoneState.phones[ip]
+}
+
+// registerPhone starts the provisioning session of phone. If another request of the phone
+// started one in the meantime, that one is returned.
+func registerPhone(phone *phoneDesc) *phoneDesc {
+	phoneState.Lock()
+	defer phoneState.Unlock()
+
+	if existing, ok := phoneState.phones[phone.IP]; ok {
+		return existing
+	}
+	phoneState.phones[phone.IP] = phone
+	return phone
+}
+
+// unregisterPhone ends the provisioning session of phone
+func unregisterPhone(phone *phoneDesc) {
+	phoneState.Lock()
+	defer phoneState.Unlock()
+
+	if phoneState.phones[phone.IP] == phone {
+		delete(phoneState.phones, phone.IP)
+	}
+}
+
+// port phones use to contact DLSir
+var listenPort string
 
 func formatItemList(items []item) string {
 	var sb strings.Builder
@@ -156,12 +207,25 @@ func itemsFromEntries(entries []config.ConfigEntry) ([]item, error) {
 }
 
 func sendConfig(c *gin.Context, phone *phoneDesc, msg message) (string, []item) {
-	conf, err := config.GetMergedConfig(confDir+"/"+phone.Mac+".conf", confDir+"/phonedefault.conf")
+	conf, err := config.GetExpandedConfig(confDir+"/"+phone.Mac+".conf", confDir+"/phonedefault.conf", phone.device())
 	if err != nil {
 		_log(c, "Failed to read phone config: %v", err)
 		return "", []item{}
 	}
 
+	srvConf, err := config.GetConfigFile(confSrv)
+	if err != nil {
+		_log(c, "Failed to read config file %v: %v", confSrv, err)
+		return "", []item{}
+	}
+
+	err = applySipCredentials(c, srvConf, conf)
+	if err != nil {
+		_log(c, "Failed to read SIP credentials from registrar: %v", err)
+		return "", []item{}
+	}
+
+	conf.Entries = conf.GetFilteredEntries(config.LocalPrefix, false)
 	entries := conf.GetFilteredEntries("file-", false)
 	items, err := itemsFromEntries(entries)
 	if err != nil {
@@ -172,8 +236,10 @@ func sendConfig(c *gin.Context, phone *phoneDesc, msg message) (string, []item)
 	return "WriteItems", items
 }
 
-func sendFiles(c *gin.Context, phone *phoneDesc, msg message) (string, []item) {
-	conf, err := config.GetMergedConfig(confDir+"/"+phone.Mac+".conf", confDir+"/phonedefault.conf")
+// sendFiles deploys the files of the phone config; only limits the deployment to the files served
+// with these names (nil: all files)
+func sendFiles(c *gin.Context, phone *phoneDesc, msg message, only []string) (string, []item) {
+	conf, err := config.GetMergedConfig(confDir+"/"+phone.Mac+".conf", confDir+"/phonedefault.conf", phone.device())
 	if err != nil {
 		_log(c, "Failed to read phone conf: %v", err)
 		return "", []item{}
@@ -181,15 +247,44 @@ func sendFiles(c *gin.Context, phone *phoneDesc, msg message) (string, []item) {
 
 	entries := conf.GetFilteredEntries("file-", true)
 
-	localHost := c.Request.Host
+	phone.PendingFiles = make([]string, 0)
+	phone.Generated = make(map[string]*generatedFile)
+	phone.Converted = make(map[string]string)
 
+	// indices of files which can't be deployed
+	dropped := make(map[string]bool)
 	for idx := range entries {
-		if entries[idx].Name == "file-name" {
-			entries[idx].Name = "file-https-base-url"
-			entries[idx].Value = fmt.Sprintf("https://%v/file/%v", localHost, entries[idx].Value)
+		if entries[idx].Name != "file-name" {
+			continue
+		}
+
+		fileType := ""
+		if typeEntry := findEntry(entries, "file-type", entries[idx].Index); typeEntry != nil {
+			fileType = typeEntry.Value
+		}
+
+		name, err := prepareFile(c, phone, conf, entries[idx].Value, fileType)
+		if err != nil {
+			_log(c, "ERROR: Not deploying %v = %v: %v", entries[idx].Key(), entries[idx].Value, err)
+			dropped[entries[idx].Index] = true
+			continue
+		}
+		if only != nil && !slices.Contains(only, name) {
+			dropped[entries[idx].Index] = true
+			continue
+		}
+
+		phone.PendingFiles = append(phone.PendingFiles, name)
+		entries[idx].Name = "file-https-base-url"
+		entries[idx].Value = fileURL(c, phone, name)
+
+		if findEntry(entries, "file-priority", entries[idx].Index) == nil {
+			entries = append(entries, config.ConfigEntry{Name: "file-priority", Index: entries[idx].Index, Value: fileTypes[fileType].Priority})
 		}
 	}
 
+	entries = slices.DeleteFunc(entries, func(entry config.ConfigEntry) bool { return dropped[entry.Index] })
+
 	items, err := itemsFromEntries(entries)
 	if err != nil {
 		_log(c, "Failed to convert config entries to phone items: %v", err)
@@ -202,25 +297,23 @@ func sendFiles(c *gin.Context, phone *phoneDesc, msg message) (string, []item) {
 func sendSoftware(c *gin.Context, phone *phoneDesc, msg message) (string, []item) {
 	items := make([]item, 0)
 
-	localHost := c.Request.Host
-	conf, err := config.GetConfigFile(confSrv)
-	if err != nil {
-		_log(c, "Failed to read config file %v: %v", confSrv, err)
+	fw := phone.FwTarget
+	if fw == nil {
+		_log(c, "No target firmware selected for phone %v", phone.Number)
+		_log(c, "This is strange; we should not have ended up here!")
 		return "", []item{}
 	}
 
-	fwConfigName := config.GetFwItemName(phone.DevType)
-	fwFile, err := conf.GetEntry(fwConfigName)
+	err := fw.CheckDeployable(phone.DevType, phone.FwType)
 	if err != nil {
-		_log(c, "Failed to read firmware file from configuration; missing entry fw-openstage40")
-		_log(c, "This is strange; we should not have ended up here!")
+		_log(c, "ERROR: Refusing to deploy firmware: %v", err)
 		return "", []item{}
 	}
 
-	fw, err := firmware.GetFirmwareInfo("files/" + fwFile.Value)
+	// the image must still be in the catalog and match its manifest
+	_, err = fwCatalog.File(fileName(fw))
 	if err != nil {
-		_log(c, "Failed to read firmware version from file; maybe not a proper firmware file?")
-		_log(c, "Error: %v", err.Error())
+		_log(c, "ERROR: Refusing to deploy firmware: %v", err)
 		return "", []item{}
 	}
 
@@ -228,17 +321,35 @@ func sendSoftware(c *gin.Context, phone *phoneDesc, msg message) (string, []item
 	_log(c, " - old version: %v", phone.FwVersion)
 	_log(c, " - new version: %v", fw.FwVersion)
 
-	items = append(items, item{Name: "file-https-base-url", Index: 0, Value: fmt.Sprintf("https://%v/file/%v", localHost, fwFile.Value)})
+	phone.PendingFiles = []string{fileName(fw)}
+	items = append(items, item{Name: "file-https-base-url", Index: 0, Value: fileURL(c, phone, fileName(fw))})
 	items = append(items, item{Name: "file-priority", Index: 0, Value: "immediate"})
 	items = append(items, item{Name: "file-sw-type", Index: 0, Value: fw.FwType})
 	items = append(items, item{Name: "file-sw-version", Index: 0, Value: fw.FwVersion.String()})
 	items = append(items, item{Name: "file-type", Index: 0, Value: "APP"})
 
 	_log(c, "Sending: %v", formatItemList(items))
+	rollouts.UpdateStarted(phone)
+	inventory.UpdateStarted(phone, fw.FwVersion)
 
 	return "SoftwareDeployment", items
 }
 
+// deploySoftware issues the software update. If the firmware can't be deployed, the phone's
+// deployment slot is released and provisioning finishes with ReadAllItems.
+func deploySoftware(c *gin.Context, phone *phoneDesc, msg message) (string, []item) {
+	action, items := sendSoftware(c, phone, msg)
+	if action == "" {
+		phone.FwNeedsUpdate = false
+		deployments.Release(phone)
+		phone.NextStep = RequestConfig
+		return readAllItems(phone, msg)
+	}
+
+	phone.NextStep = WaitForUpdate
+	return action, items
+}
+
 func readAllItems(phone *phoneDesc, msg message) (string, []item) {
 	return "ReadAllItems", []item{}
 }
@@ -261,36 +372,22 @@ func checkReply(c *gin.Context, phone *phoneDesc, msg message) bool {
 	return msg.Reason.Status == "accepted"
 }
 
-func findItem(items []item, name string, index int) *item {
-	for _, item := range items {
-		if item.Name == name && item.Index == index {
-			return &item
+func findEntry(entries []config.ConfigEntry, name string, index string) *config.ConfigEntry {
+	for idx := range entries {
+		if entries[idx].Name == name && entries[idx].Index == index {
+			return &entries[idx]
 		}
 	}
 	return nil
 }
 
-func checkStatus(c *gin.Context, phone *phoneDesc, msg message) bool {
-	for _, item := range msg.Items {
-		if item.Name == "file-deployment-name" {
-			statusItem := findItem(msg.Items, "file-deployment-status", item.Index)
-
-			_log(c, "  - File '%v' upload %v\n", item.Value, statusItem.Value)
+func findItem(items []item, name string, index int) *item {
+	for _, item := range items {
+		if item.Name == name && item.Index == index {
+			return &item
 		}
 	}
-
-	// _log(c, "WARNING: Status response does not contain required items!\n");
-	// spew.Dump(msg)
-
-	return true
-}
-
-func getFile(c *gin.Context) {
-	file := c.Params.ByName("file")
-
-	_log(c, "Got GET request for file %v\n", file)
-
-	c.FileAttachment("./files/"+file, file)
+	return nil
 }
 
 func itemByName(items []item, name string) *string {
@@ -315,7 +412,8 @@ func postLoginService(c *gin.Context) {
 
 	// try to find phone number in response
 	phoneIP := c.RemoteIP()
-	if _, ok := phoneState[phoneIP]; !ok {
+	phone := lookupPhone(phoneIP)
+	if phone == nil {
 		phoneNoPtr := itemByName(msg.Items, "e164")
 		phoneNo := "?"
 		if phoneNoPtr != nil {
@@ -352,30 +450,92 @@ func postLoginService(c *gin.Context) {
 			return
 		}
 
-		fwConfigName := config.GetFwItemName(*devType)
-		fwFile, err := conf.GetEntry(fwConfigName)
+		refreshFirmwareCatalog(conf)
+
+		device := config.Device{Type: *devType, FwVersion: *ver}
+		phoneConf, err := config.GetMergedConfig(confDir+"/"+*phoneMac+".conf", confDir+"/phonedefault.conf", device)
+		if err != nil {
+			_log(c, "Failed to read phone config; ignoring phone-specific firmware settings: %v", err)
+			phoneConf = &config.ConfigFile{Entries: []config.ConfigEntry{}}
+		}
+
 		needsUpdate := false
+		var target *firmware.FirmwareInfo
+		policy, err := getFirmwarePolicy(conf, phoneConf, *devType, *fwType)
 		if err == nil {
-			myVersion, err := firmware.GetFirmwareInfo("files/" + fwFile.Value)
+			err = policy.Target.CheckDeployable(*devType, *fwType)
 			if err != nil {
-				_log(c, "Failed to read firmware version from file; maybe not a proper firmware file?")
-				_log(c, "Error: %v", err.Error())
-			} else {
-				needsUpdate = ver.Compare(myVersion.FwVersion) < 0
-				if needsUpdate {
-					_log(c, "Phone is running old firmware, is: %v, should be: %v", *ver, myVersion.FwVersion)
-				} else {
-					_log(c, "Phone is running most recent firmware %v", *ver)
-				}
+				_log(c, "ERROR: Refusing to deploy configured firmware: %v", err)
 			}
 		} else {
-			_log(c, "I don't have a firmware for %v (configure as %v)", *devType, fwConfigName)
+			_log(c, "I don't have a firmware for this phone: %v", err)
+		}
+
+		if err == nil {
+			_log(c, "Firmware policy: %v", policy)
+			target = policy.Target
+			needsUpdate = policy.NeedsUpdate(*ver)
+			cmp := ver.Compare(target.FwVersion)
+			if cmp < 0 {
+				_log(c, "Phone is running old firmware, is: %v, should be: %v", *ver, target.FwVersion)
+			} else if cmp > 0 && needsUpdate {
+				_log(c, "Phone is running newer firmware, is: %v, should be: %v; downgrading", *ver, target.FwVersion)
+			} else if cmp > 0 {
+				_log(c, "Phone is running newer firmware %v than %v; downgrade not allowed", *ver, target.FwVersion)
+			} else {
+				_log(c, "Phone is running target firmware %v", *ver)
+			}
+		}
+
+		phone = &phoneDesc{Mac: *phoneMac, IP: phoneIP, Number: phoneNo, NextStep: Initial, RqBegin: time.Now(), DevType: *devType, FwType: *fwType, FwVersion: *ver, FwTarget: target, FwFinal: target, FwNeedsUpdate: needsUpdate}
+
+		if phone.FwNeedsUpdate {
+			err = planUpgrade(c, phone)
+			if err != nil {
+				_log(c, "ERROR: Refusing to deploy configured firmware: %v", err)
+				phone.FwNeedsUpdate = false
+			}
+		}
+
+		if attempt := inventory.Seen(phone); attempt != nil {
+			logUpdateAttempt(c, phone, *attempt)
+			rollouts.PhoneReturned(phone, *attempt)
+		}
+
+		if phone.FwNeedsUpdate {
+			window, err := getMaintenanceWindow(conf, phoneConf)
+			if err != nil {
+				_log(c, "Invalid maintenance window; deferring software deployment: %v", err)
+				phone.FwNeedsUpdate = false
+			} else if window != nil && !window.Contains(time.Now()) {
+				next := window.NextOpen(time.Now())
+				_log(c, "Outside of maintenance window %v; deferring software deployment until %v", window, next)
+				phone.FwNeedsUpdate = false
+				scheduleContactMe(phoneIP, next)
+			}
 		}
 
-		phoneState[phoneIP] = &phoneDesc{Mac: *phoneMac, IP: phoneIP, Number: phoneNo, NextStep: Initial, RqBegin: time.Now(), DevType: *devType, FwVersion: *ver, FwNeedsUpdate: needsUpdate}
+		if phone.FwNeedsUpdate {
+			admitted, reason := rollouts.Admit(phone, phoneConf.GetGroups(), target.FwVersion, fleetSize(conf))
+			if admitted {
+				_log(c, "Firmware update: %v", reason)
+			} else {
+				_log(c, "Holding back firmware update: %v", reason)
+				phone.FwNeedsUpdate = false
+			}
+		}
+
+		if !phone.FwNeedsUpdate {
+			// the phone came back from a deployment or doesn't use a reserved slot
+			deployments.Release(phone)
+		}
+
+		phone = registerPhone(phone)
 	}
 
-	phone := phoneState[phoneIP]
+	phone.mu.Lock()
+	defer phone.mu.Unlock()
+
 	_log(c, "Request from phone '%v' with reason '%v'\n", phone.Number, msg.Reason.Value)
 	_log(c, " - Nonce: %v\n", msg.Nonce)
 	_log(c, " - local/remote IP: %v - %v\n", c.Request.Host, c.Request.RemoteAddr)
@@ -386,11 +546,29 @@ func postLoginService(c *gin.Context) {
 
 	if msg.Reason.Value == "start-up" && phone.NextStep == WaitForUpdate {
 		// we issued a software update and the phone rebooted
-		// -> software update was likely successful
-		_log(c, "Yay - phone came back after a software update; requesting current configuration")
-
-		action, responseItems = readAllItems(phone, msg)
-		phone.NextStep = RequestConfig
+		// -> check whether it runs the deployed version now
+		_log(c, "Phone came back after a software update")
+		verifyUpdate(c, phone, msg)
+
+		if phone.FwVersion.Compare(phone.FwTarget.FwVersion) == 0 && phone.FwVersion.Compare(phone.FwFinal.FwVersion) < 0 {
+			// intermediate hop of a multi-hop upgrade succeeded -> continue with the next one
+			// the phone keeps its deployment slot
+			err := planUpgrade(c, phone)
+			if err == nil && deployments.Acquire(phone) {
+				action, responseItems = deploySoftware(c, phone, msg)
+			} else {
+				if err != nil {
+					_log(c, "ERROR: Unable to continue multi-hop upgrade: %v", err)
+				}
+				deployments.Release(phone)
+				action, responseItems = readAllItems(phone, msg)
+				phone.NextStep = RequestConfig
+			}
+		} else {
+			deployments.Release(phone)
+			action, responseItems = readAllItems(phone, msg)
+			phone.NextStep = RequestConfig
+		}
 	} else if msg.Reason.Value == "start-up" || msg.Reason.Value == "solicited" {
 		// we send the full phone configuration both on startup and explicit request
 		action, responseItems = sendConfig(c, phone, msg)
@@ -402,7 +580,13 @@ func postLoginService(c *gin.Context) {
 		if wasAccepted {
 			if phone.NextStep == SendFiles {
 				_log(c, "Configuration options sent successfully, continuing with files\n")
-				action, responseItems = sendFiles(c, phone, msg)
+				phone.FileRetries = 0
+				action, responseItems = sendFiles(c, phone, msg, nil)
+				if phone.FwNeedsUpdate && !deployments.Acquire(phone) {
+					active, queued := deployments.Status()
+					_log(c, "Too many software deployments in progress (%v, %v queued); phone will be contacted again once a slot frees up", active, queued)
+					phone.FwNeedsUpdate = false
+				}
 				if phone.FwNeedsUpdate {
 					phone.NextStep = SendSoftware
 				} else {
@@ -412,21 +596,37 @@ func postLoginService(c *gin.Context) {
 			} else if phone.NextStep == RequestConfig {
 				_log(c, "Configuration finished successfully - current dump in %v\n", confDumpDir)
 				// we're done configuring the phone; wipe phone state and wait for new requests
-				delete(phoneState, phoneIP)
+				unregisterPhone(phone)
 			}
 		} else {
 			_log(c, "WARNING: Phone didn't accept previous request; aborting...")
 		}
 	} else if msg.Reason.Value == "status" {
 		// "status" is only sent after file operations
-		wasAccepted := checkStatus(c, phone, msg)
-		if !wasAccepted {
-			_log(c, "WARNING: Phone didn't accept previous request")
+		failed := checkStatus(c, phone, msg)
+
+		onFailure := fileFailure
+		if len(failed) == 0 {
+			onFailure = FileFailureContinue
+		} else if onFailure == FileFailureRetry && phone.FileRetries >= fileRetries {
+			_log(c, "WARNING: %v files still failed after %v retries; continuing", len(failed), fileRetries)
+			onFailure = FileFailureContinue
 		}
 
-		if phone.NextStep == SendSoftware {
-			action, responseItems = sendSoftware(c, phone, msg)
-			phone.NextStep = WaitForUpdate
+		if onFailure == FileFailureRetry {
+			phone.FileRetries++
+			_log(c, "Deploying %v failed files again (retry %v of %v)", len(failed), phone.FileRetries, fileRetries)
+			action, responseItems = sendFiles(c, phone, msg, failed)
+		} else if onFailure == FileFailureAbort {
+			_log(c, "WARNING: %v files failed; skipping the remaining provisioning steps", len(failed))
+			if phone.NextStep == SendSoftware {
+				phone.FwNeedsUpdate = false
+				deployments.Release(phone)
+			}
+			action, responseItems = readAllItems(phone, msg)
+			phone.NextStep = RequestConfig
+		} else if phone.NextStep == SendSoftware {
+			action, responseItems = deploySoftware(c, phone, msg)
 		} else if phone.NextStep == RequestConfig {
 			action, responseItems = readAllItems(phone, msg)
 			// phone.NextStep = RequestConfig
@@ -457,110 +657,122 @@ func postLoginService(c *gin.Context) {
 	}
 }
 
-type connDialer struct {
-	c net.Conn
-}
-
-func (cd connDialer) Dial(network, addr string) (net.Conn, error) {
-	return cd.c, nil
-}
-
-func sendContactMe(listenPort, host string) {
-	conn, err := net.Dial("tcp", net.JoinHostPort(host, "8085"))
-	if err != nil {
-		_log(nil, "Connection to %v failed - %v", host, err)
-		return
-	}
-	defer conn.Close()
+func requireConfigEntry(conf config.ConfigFile, name string) config.ConfigEntry {
+	entry, err := conf.GetEntry(name)
 
-	connIP, _, err := net.SplitHostPort(conn.LocalAddr().String())
 	if err != nil {
-		_log(nil, "Failed to parse local addr - this should not happen: %v", err)
-		return
+		_log(nil, "Failed to find required entry %v\n", name)
+		os.Exit(1)
+		panic("")
 	}
 
-	_log(nil, "Sending ContactMe to %v; %v:%v\n", host, connIP, listenPort)
+	return entry
+}
 
-	client := http.Client{Transport: &http.Transport{Dial: connDialer{conn}.Dial}}
-	url := fmt.Sprintf("http://%v:8085/contact_dls.html/ContactDLS", host)
-	body := strings.NewReader(fmt.Sprintf("ContactMe=true&dls_ip_addr=%v&dls_ip_port=%v", connIP, listenPort))
+func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1], os.Args[2:]))
+	}
 
-	response, err := client.Post(url, "application/x-www-form-urlencoded", body)
+	runServer()
+}
 
+func runServer() {
+	conf, err := config.GetConfigFile(confSrv)
 	if err != nil {
-		_log(nil, "ContactMe for %v failed: %v", host, err)
-	} else if response.StatusCode != 204 {
-		_log(nil, "Unexpected response from %v for ContactMe: %v", host, response.Status)
-	} else {
-		_log(nil, "ContactMe successfully sent to %v\n", host)
+		_log(nil, "Failed to read config file %v: %v", confSrv, err)
+		os.Exit(1)
 	}
-}
 
-func timerFunc(managedPhones []config.ConfigEntry, manageInterval time.Duration, listenPort string) {
-	ticker := time.NewTicker(manageInterval)
-	defer ticker.Stop()
+	listenIP := requireConfigEntry(*conf, "listen-ip").Value
+	listenPort = requireConfigEntry(*conf, "listen-port").Value
+
+	tlsCert := requireConfigEntry(*conf, "tls-cert-file").Value
+	tlsKey := requireConfigEntry(*conf, "tls-key-file").Value
 
-	_log(nil, "Sending initial ContactMe to %v phones\n", len(managedPhones))
-	for {
-		for _, phone := range managedPhones {
-			sendContactMe(listenPort, phone.Value)
+	managedPhones := conf.GetFilteredEntries("managed-phones", true)
 
-			// XXX optionally wait between individual phones
-			// this makes the log cleaner for debugging,
-			// but does not serve any further purpose
-			time.Sleep(5 * time.Second)
-		}
+	refreshFirmwareCatalog(conf)
 
-		<-ticker.C
-		_log(nil, "Ticker elapsed; sending ContactMe to %v phones\n", len(managedPhones))
+	err = deployments.configure(conf)
+	if err != nil {
+		_log(nil, "%v", err)
+		os.Exit(1)
 	}
-}
 
-func requireConfigEntry(conf config.ConfigFile, name string) config.ConfigEntry {
-	entry, err := conf.GetEntry(name)
+	err = configureFileURLs(conf)
+	if err != nil {
+		_log(nil, "%v", err)
+		os.Exit(1)
+	}
 
+	err = configureContactMe(conf)
 	if err != nil {
-		_log(nil, "Failed to find required entry %v\n", name)
+		_log(nil, "%v", err)
 		os.Exit(1)
-		panic("")
 	}
 
-	return entry
-}
+	err = configureContactSchedules(conf)
+	if err != nil {
+		_log(nil, "%v", err)
+		os.Exit(1)
+	}
 
-func main() {
-	phoneState = make(map[string]*phoneDesc)
+	err = configureFileFailures(conf)
+	if err != nil {
+		_log(nil, "%v", err)
+		os.Exit(1)
+	}
 
-	conf, err := config.GetConfigFile(confSrv)
+	err = configureCertificates(conf)
 	if err != nil {
-		_log(nil, "Failed to read config file %v: %v", confSrv, err)
+		_log(nil, "%v", err)
 		os.Exit(1)
 	}
 
-	listenIP := requireConfigEntry(*conf, "listen-ip").Value
-	listenPort := requireConfigEntry(*conf, "listen-port").Value
+	if entry, err := conf.GetEntry("file-rate-limit"); err == nil {
+		fileRateLimit, err = parseByteRate(entry.Value)
+		if err != nil {
+			_log(nil, "Failed to parse file-rate-limit: %v", err)
+			os.Exit(1)
+		}
+	}
 
-	tlsCert := requireConfigEntry(*conf, "tls-cert-file").Value
-	tlsKey := requireConfigEntry(*conf, "tls-key-file").Value
+	err = rollouts.load()
+	if err != nil {
+		_log(nil, "Failed to load rollouts: %v", err)
+		os.Exit(1)
+	}
 
-	managedPhones := conf.GetFilteredEntries("managed-phones", true)
+	err = inventory.load()
+	if err != nil {
+		_log(nil, "Failed to load inventory: %v", err)
+		os.Exit(1)
+	}
 
-	manageIntervalStr := requireConfigEntry(*conf, "manage-interval").Value
-	manageInterval, err := time.ParseDuration(manageIntervalStr)
+	err = loadSchedules(conf, managedPhones)
 	if err != nil {
-		_log(nil, "Failed to parse manage-interval '%v'\n", manageIntervalStr)
+		_log(nil, "Failed to load contact schedules: %v", err)
 		os.Exit(1)
 	}
 
-	go timerFunc(managedPhones, manageInterval, listenPort)
+	go scheduleFunc(listenPort)
+	go rolloutTimerFunc()
+	go deploymentTimerFunc()
 
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.Default()
 	_ = router.SetTrustedProxies(nil)
 
-	router.GET("/file/:file", getFile)
+	registerFileRoutes(router)
 	router.POST("/DeploymentService/LoginService", postLoginService)
 
+	if apiToken, err := conf.GetEntry("api-token"); err == nil && apiToken.Value != "" {
+		registerAPI(router, apiToken.Value)
+	} else {
+		_log(nil, "No api-token configured; management API is disabled")
+	}
+
 	err = router.RunTLS(fmt.Sprintf("%v:%v", listenIP, listenPort), tlsCert, tlsKey)
 	if err != nil {
 		_log(nil, "Failed to start server: %v", err)
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zam-haus/dlsir/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Default validity of download URLs
+const defaultFileURLLifetime = time.Hour
+
+// Key for signing download URLs (file-signing-key); random unless configured
+var fileSigningKey []byte
+
+var fileURLLifetime = defaultFileURLLifetime
+
+// configureFileURLs reads file-signing-key and file-url-lifetime from the server config
+func configureFileURLs(srvConf *config.ConfigFile) error {
+	if entry, err := srvConf.GetEntry("file-signing-key"); err == nil && entry.Value != "" {
+		fileSigningKey = []byte(entry.Value)
+	} else {
+		fileSigningKey = make([]byte, 32)
+		_, err := rand.Read(fileSigningKey)
+		if err != nil {
+			return fmt.Errorf("failed to generate file signing key: %v", err)
+		}
+	}
+
+	if entry, err := srvConf.GetEntry("file-url-lifetime"); err == nil {
+		lifetime, err := time.ParseDuration(entry.Value)
+		if err != nil || lifetime <= 0 {
+			return fmt.Errorf("invalid file-url-lifetime '%v'", entry.Value)
+		}
+		fileURLLifetime = lifetime
+	}
+
+	return nil
+}
+
+func fileTokenSignature(mac string, expires int64, name string) string {
+	sig := hmac.New(sha256.New, fileSigningKey)
+	fmt.Fprintf(sig, "%v|%v|%v", mac, expires, name)
+	return hex.EncodeToString(sig.Sum(nil))
+}
+
+// signFileToken returns a token allowing the phone with the given MAC address to download
+// the file until expires; format: <mac>.<expiry as unix time>.<HMAC-SHA256>
+func signFileToken(mac string, name string, expires time.Time) string {
+	return fmt.Sprintf("%v.%v.%v", mac, expires.Unix(), fileTokenSignature(mac, expires.Unix(), name))
+}
+
+// verifyFileToken checks signature and expiry of a token for the file and returns the MAC address it is bound to
+func verifyFileToken(token string, name string, now time.Time) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("malformed token")
+	}
+
+	mac := parts[0]
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("malformed token")
+	}
+
+	if !hmac.Equal([]byte(parts[2]), []byte(fileTokenSignature(mac, expires, name))) {
+		return "", fmt.Errorf("invalid signature")
+	}
+	if now.Unix() > expires {
+		return "", fmt.Errorf("token expired at %v", time.Unix(expires, 0))
+	}
+
+	return mac, nil
+}
+
+// fileURL returns the signed URL the phone downloads a file of its current deployment from
+func fileURL(c *gin.Context, phone *phoneDesc, name string) string {
+	token := signFileToken(phone.Mac, name, time.Now().Add(fileURLLifetime))
+	return fmt.Sprintf("https://%v/file/%v/%v", c.Request.Host, token, name)
+}
+
+// registerFileRoutes registers the download URLs returned by fileURL
+func registerFileRoutes(router gin.IRoutes) {
+	router.GET("/file/:token/:file", getFile)
+	router.HEAD("/file/:token/:file", getFile)
+}
+
+// confinedPath returns the path of a file in files/; names escaping the directory are rejected
+func confinedPath(name string) (string, error) {
+	if name == "" || name == "." || name == ".." || name != filepath.Base(name) || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("invalid file name '%v'", name)
+	}
+
+	root, err := filepath.EvalSymlinks(filesDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %v: %v", filesDir, err)
+	}
+
+	// symlinks must not point outside of files/ either
+	path, err := filepath.EvalSymlinks(filepath.Join(root, name))
+	if err != nil {
+		return "", fmt.Errorf("file '%v' not found", name)
+	}
+
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("file '%v' is outside of %v", name, filesDir)
+	}
+
+	return path, nil
+}
+
+// getFile serves a file of files/ to a phone. Only the files of the phone's active deployment
+// can be downloaded, using a valid token bound to the phone's MAC address.
+func getFile(c *gin.Context) {
+	token := c.Params.ByName("token")
+	file := c.Params.ByName("file")
+
+	_log(c, "Got GET request for file %v\n", file)
+
+	mac, err := verifyFileToken(token, file, time.Now())
+	if err != nil {
+		_log(c, "WARNING: Denied download of %v: %v", file, err)
+		c.Status(http.StatusForbidden)
+		return
+	}
+
+	phone := lookupPhone(c.RemoteIP())
+	if phone == nil {
+		_log(c, "WARNING: Denied download of %v: no active deployment session of %v from this address", file, mac)
+		c.Status(http.StatusForbidden)
+		return
+	}
+
+	// the phone's next request may replace its files while this one is served
+	phone.mu.Lock()
+	number := phone.Number
+	active := phone.Mac == mac
+	pending := slices.Contains(phone.PendingFiles, file)
+	generated := phone.Generated[file]
+	converted, isConverted := phone.Converted[file]
+	phone.mu.Unlock()
+
+	if !active {
+		_log(c, "WARNING: Denied download of %v: no active deployment session of %v from this address", file, mac)
+		c.Status(http.StatusForbidden)
+		return
+	}
+
+	if !pending {
+		_log(c, "WARNING: Denied download of %v by phone %v: file is not part of its active deployment", file, number)
+		c.Status(http.StatusForbidden)
+		return
+	}
+
+	if fileRateLimit > 0 {
+		c.Writer = newThrottledWriter(c.Writer, fileRateLimit)
+	}
+
+	if generated != nil {
+		etag := fmt.Sprintf(`"%x"`, sha256.Sum256(generated.Content))
+		serveContent(c, file, generated.ModTime, bytes.NewReader(generated.Content), etag)
+		return
+	}
+
+	// validated (and possibly converted) by sendFiles
+	if isConverted {
+		serveFile(c, converted, file)
+		return
+	}
+
+	path, err := confinedPath(file)
+	if err != nil {
+		_log(c, "WARNING: Denied download of %v by phone %v: %v", file, number, err)
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	serveFile(c, path, file)
+}
+
+type fileHash struct {
+	Size    int64
+	ModTime time.Time
+	ETag    string
+}
+
+// strong ETags by path; only recomputed if size or modification time changed
+var fileHashes = struct {
+	sync.Mutex
+	hashes map[string]fileHash
+}{hashes: make(map[string]fileHash)}
+
+// fileETag returns a strong ETag based on the SHA-256 of the file content
+func fileETag(path string, f *os.File, fi os.FileInfo) (string, error) {
+	fileHashes.Lock()
+	defer fileHashes.Unlock()
+
+	if hash, ok := fileHashes.hashes[path]; ok && hash.Size == fi.Size() && hash.ModTime.Equal(fi.ModTime()) {
+		return hash.ETag, nil
+	}
+
+	sum := sha256.New()
+	_, err := io.Copy(sum, f)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash %v: %v", path, err)
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+
+	etag := `"` + hex.EncodeToString(sum.Sum(nil)) + `"`
+	fileHashes.hashes[path] = fileHash{Size: fi.Size(), ModTime: fi.ModTime(), ETag: etag}
+	return etag, nil
+}
+
+// serveFile sends the file as attachment; byte ranges (to resume interrupted downloads) and
+// conditional requests (If-None-Match, If-Match, If-Range, If-Modified-Since) are supported
+func serveFile(c *gin.Context, path string, name string) {
+	f, err := os.Open(path)
+	if err != nil {
+		_log(c, "Failed to open %v: %v", path, err)
+		c.Status(http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	etag, err := fileETag(path, f, fi)
+	if err != nil {
+		_log(c, "%v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	serveContent(c, name, fi.ModTime(), f, etag)
+}
+
+// serveContent sends content as attachment, honoring range and conditional requests
+func serveContent(c *gin.Context, name string, modTime time.Time, content io.ReadSeeker, etag string) {
+	if rng := c.GetHeader("Range"); rng != "" {
+		_log(c, " - range: %v", rng)
+	}
+
+	c.Header("ETag", etag)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%v"`, name))
+	http.ServeContent(c.Writer, c.Request, name, modTime, content)
+}
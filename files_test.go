@@ -0,0 +1,320 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	testContent = "0123456789abcdefghij"
+	testMac     = "00:1a:2b:3c:4d:5e"
+	testIP      = "192.0.2.10"
+)
+
+// fileServer serves the download routes to a phone with an active deployment session
+type fileServer struct {
+	router  http.Handler
+	phone   *phoneDesc
+	modTime time.Time
+}
+
+// newFileServer registers a session of testMac at testIP with the pending files
+//   - logo.bmp: converted by sendFiles (testContent)
+//   - ringer.wav: in files/ (testContent)
+//   - phone.txt: generated ("generated")
+//
+// and runs the test in a temporary directory holding files/
+func newFileServer(t *testing.T) *fileServer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	inTempDir(t)
+
+	oldKey, oldRate := fileSigningKey, fileRateLimit
+	fileSigningKey, fileRateLimit = []byte("test-key"), 0
+	t.Cleanup(func() { fileSigningKey, fileRateLimit = oldKey, oldRate })
+
+	modTime := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	write := func(path string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(testContent), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(filesDir, "ringer.wav"))
+	write(filepath.Join("converted", "logo.bmp"))
+
+	phone := &phoneDesc{
+		Mac:          testMac,
+		IP:           testIP,
+		Number:       "4242",
+		PendingFiles: []string{"logo.bmp", "ringer.wav", "phone.txt"},
+		Generated:    map[string]*generatedFile{"phone.txt": {Content: []byte("generated"), ModTime: modTime}},
+		Converted:    map[string]string{"logo.bmp": filepath.Join("converted", "logo.bmp")},
+	}
+	if registerPhone(phone) != phone {
+		t.Fatalf("phone session of %v already exists", testIP)
+	}
+	t.Cleanup(func() { unregisterPhone(phone) })
+
+	r := gin.New()
+	registerFileRoutes(r)
+	return &fileServer{router: r, phone: phone, modTime: modTime}
+}
+
+// url returns a download URL of the file signed for mac
+func (srv *fileServer) url(mac string, name string) string {
+	return "/file/" + signFileToken(mac, name, time.Now().Add(time.Hour)) + "/" + name
+}
+
+// request sends a request from the phone's address
+func (srv *fileServer) request(method string, url string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, nil)
+	req.RemoteAddr = testIP + ":40000"
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	srv.router.ServeHTTP(w, req)
+	return w
+}
+
+func TestGetFile(t *testing.T) {
+	srv := newFileServer(t)
+
+	tests := []struct {
+		name string
+		file string
+		body string
+	}{
+		{"converted", "logo.bmp", testContent},
+		{"from files/", "ringer.wav", testContent},
+		{"generated", "phone.txt", "generated"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := srv.request(http.MethodGet, srv.url(testMac, tt.file), nil)
+			if w.Code != http.StatusOK || w.Body.String() != tt.body {
+				t.Fatalf("GET = %v %q, want 200 %q", w.Code, w.Body.String(), tt.body)
+			}
+			if w.Header().Get("ETag") == "" {
+				t.Errorf("GET didn't send an ETag")
+			}
+			if got := w.Header().Get("Content-Disposition"); got != `attachment; filename="`+tt.file+`"` {
+				t.Errorf("Content-Disposition = %q", got)
+			}
+			if got := w.Header().Get("Accept-Ranges"); got != "bytes" {
+				t.Errorf("Accept-Ranges = %q, want bytes", got)
+			}
+		})
+	}
+
+	// the ETag is cached, but must stay the same
+	first := srv.request(http.MethodGet, srv.url(testMac, "logo.bmp"), nil).Header().Get("ETag")
+	second := srv.request(http.MethodGet, srv.url(testMac, "logo.bmp"), nil).Header().Get("ETag")
+	if first != second {
+		t.Errorf("ETag changed from %q to %q", first, second)
+	}
+}
+
+func TestGetFileDenied(t *testing.T) {
+	srv := newFileServer(t)
+
+	expired := "/file/" + signFileToken(testMac, "logo.bmp", time.Now().Add(-time.Minute)) + "/logo.bmp"
+	otherFile := "/file/" + signFileToken(testMac, "ringer.wav", time.Now().Add(time.Hour)) + "/logo.bmp"
+	// a valid token, but the phone at testIP has another MAC address
+	otherPhone := srv.url("00:1a:2b:3c:4d:5f", "logo.bmp")
+
+	tests := []struct {
+		name string
+		url  string
+		ip   string
+		code int
+	}{
+		{"malformed token", "/file/token/logo.bmp", testIP, http.StatusForbidden},
+		{"forged signature", "/file/" + testMac + ".9999999999.0000/logo.bmp", testIP, http.StatusForbidden},
+		{"expired token", expired, testIP, http.StatusForbidden},
+		{"token for another file", otherFile, testIP, http.StatusForbidden},
+		{"token for another phone", otherPhone, testIP, http.StatusForbidden},
+		{"no session at address", srv.url(testMac, "logo.bmp"), "192.0.2.11", http.StatusForbidden},
+		{"not pending", srv.url(testMac, "other.bmp"), testIP, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			req.RemoteAddr = tt.ip + ":40000"
+			w := httptest.NewRecorder()
+			srv.router.ServeHTTP(w, req)
+
+			if w.Code != tt.code {
+				t.Errorf("status = %v, want %v", w.Code, tt.code)
+			}
+			if w.Body.String() == testContent {
+				t.Errorf("file was served")
+			}
+		})
+	}
+
+	// pending, but neither generated, converted nor in files/
+	srv.phone.mu.Lock()
+	srv.phone.PendingFiles = append(srv.phone.PendingFiles, "missing.bmp")
+	srv.phone.mu.Unlock()
+	if w := srv.request(http.MethodGet, srv.url(testMac, "missing.bmp"), nil); w.Code != http.StatusNotFound {
+		t.Errorf("missing file: status = %v, want 404", w.Code)
+	}
+}
+
+func TestGetFileRange(t *testing.T) {
+	srv := newFileServer(t)
+	etag := srv.request(http.MethodGet, srv.url(testMac, "logo.bmp"), nil).Header().Get("ETag")
+
+	tests := []struct {
+		name    string
+		file    string
+		headers map[string]string
+		code    int
+		body    string
+		rng     string
+	}{
+		{"range", "logo.bmp", map[string]string{"Range": "bytes=2-5"}, http.StatusPartialContent, "2345", "bytes 2-5/20"},
+		{"open range", "logo.bmp", map[string]string{"Range": "bytes=15-"}, http.StatusPartialContent, "fghij", "bytes 15-19/20"},
+		{"suffix range", "ringer.wav", map[string]string{"Range": "bytes=-3"}, http.StatusPartialContent, "hij", "bytes 17-19/20"},
+		{"generated", "phone.txt", map[string]string{"Range": "bytes=3-"}, http.StatusPartialContent, "erated", "bytes 3-8/9"},
+		{"unsatisfiable", "logo.bmp", map[string]string{"Range": "bytes=30-40"}, http.StatusRequestedRangeNotSatisfiable, "", "bytes */20"},
+		{"if-range matches", "logo.bmp", map[string]string{"Range": "bytes=2-5", "If-Range": etag}, http.StatusPartialContent, "2345", "bytes 2-5/20"},
+		{"if-range changed", "logo.bmp", map[string]string{"Range": "bytes=2-5", "If-Range": `"changed"`}, http.StatusOK, testContent, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := srv.request(http.MethodGet, srv.url(testMac, tt.file), tt.headers)
+			if w.Code != tt.code {
+				t.Fatalf("status = %v, want %v", w.Code, tt.code)
+			}
+			if tt.code != http.StatusRequestedRangeNotSatisfiable && w.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", w.Body.String(), tt.body)
+			}
+			if got := w.Header().Get("Content-Range"); got != tt.rng {
+				t.Errorf("Content-Range = %q, want %q", got, tt.rng)
+			}
+		})
+	}
+}
+
+func TestGetFileConditional(t *testing.T) {
+	srv := newFileServer(t)
+	etag := srv.request(http.MethodGet, srv.url(testMac, "logo.bmp"), nil).Header().Get("ETag")
+	modified := srv.modTime.Format(http.TimeFormat)
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		code    int
+	}{
+		{"if-none-match", map[string]string{"If-None-Match": etag}, http.StatusNotModified},
+		{"if-none-match list", map[string]string{"If-None-Match": `"other", ` + etag}, http.StatusNotModified},
+		{"if-none-match any", map[string]string{"If-None-Match": "*"}, http.StatusNotModified},
+		{"if-none-match changed", map[string]string{"If-None-Match": `"other"`}, http.StatusOK},
+		{"if-match", map[string]string{"If-Match": etag}, http.StatusOK},
+		{"if-match changed", map[string]string{"If-Match": `"other"`}, http.StatusPreconditionFailed},
+		{"if-modified-since unchanged", map[string]string{"If-Modified-Since": modified}, http.StatusNotModified},
+		{"if-modified-since later", map[string]string{"If-Modified-Since": srv.modTime.Add(time.Hour).Format(http.TimeFormat)}, http.StatusNotModified},
+		{"if-modified-since modified", map[string]string{"If-Modified-Since": srv.modTime.Add(-time.Hour).Format(http.TimeFormat)}, http.StatusOK},
+		// If-None-Match takes precedence over If-Modified-Since
+		{"if-none-match changed and not modified", map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": modified}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := srv.request(http.MethodGet, srv.url(testMac, "logo.bmp"), tt.headers)
+			if w.Code != tt.code {
+				t.Fatalf("status = %v, want %v", w.Code, tt.code)
+			}
+			if tt.code == http.StatusNotModified && w.Body.Len() != 0 {
+				t.Errorf("304 with body %q", w.Body.String())
+			}
+			if tt.code == http.StatusOK && w.Body.String() != testContent {
+				t.Errorf("body = %q, want %q", w.Body.String(), testContent)
+			}
+		})
+	}
+
+	// generated files have an ETag of their content
+	generated := srv.request(http.MethodGet, srv.url(testMac, "phone.txt"), nil).Header().Get("ETag")
+	w := srv.request(http.MethodGet, srv.url(testMac, "phone.txt"), map[string]string{"If-None-Match": generated})
+	if w.Code != http.StatusNotModified {
+		t.Errorf("generated file with If-None-Match: status = %v, want 304", w.Code)
+	}
+}
+
+func TestGetFileHead(t *testing.T) {
+	srv := newFileServer(t)
+
+	w := srv.request(http.MethodHead, srv.url(testMac, "logo.bmp"), nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("HEAD = %v, want 200", w.Code)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("HEAD returned body %q", w.Body.String())
+	}
+	if got := w.Header().Get("Content-Length"); got != strconv.Itoa(len(testContent)) {
+		t.Errorf("Content-Length = %q, want %v", got, len(testContent))
+	}
+	if got := w.Header().Get("Last-Modified"); got != srv.modTime.Format(http.TimeFormat) {
+		t.Errorf("Last-Modified = %q, want %q", got, srv.modTime.Format(http.TimeFormat))
+	}
+	if got, want := w.Header().Get("ETag"), srv.request(http.MethodGet, srv.url(testMac, "logo.bmp"), nil).Header().Get("ETag"); got != want {
+		t.Errorf("HEAD ETag = %q, GET ETag = %q", got, want)
+	}
+
+	// HEAD is checked like GET
+	w = srv.request(http.MethodHead, srv.url(testMac, "other.bmp"), nil)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("HEAD of a file not pending = %v, want 403", w.Code)
+	}
+}
+
+func TestGetFileThrottled(t *testing.T) {
+	srv := newFileServer(t)
+
+	content := make([]byte, 30*1024)
+	for idx := range content {
+		content[idx] = byte(idx)
+	}
+	err := os.WriteFile(filepath.Join(filesDir, "ringer.wav"), content, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 30 KiB at 100 KiB/s take 0.3 s
+	fileRateLimit = 100 * 1024
+	start := time.Now()
+	w := srv.request(http.MethodGet, srv.url(testMac, "ringer.wav"), nil)
+	elapsed := time.Since(start)
+
+	if w.Code != http.StatusOK || w.Body.Len() != len(content) || w.Body.String() != string(content) {
+		t.Fatalf("GET = %v with %v bytes, want 200 with %v bytes", w.Code, w.Body.Len(), len(content))
+	}
+	if elapsed < 250*time.Millisecond {
+		t.Errorf("download took %v, want at least 0.25 s", elapsed)
+	}
+
+	w = srv.request(http.MethodGet, srv.url(testMac, "ringer.wav"), map[string]string{"Range": "bytes=1024-2047"})
+	if w.Code != http.StatusPartialContent || w.Body.String() != string(content[1024:2048]) {
+		t.Errorf("throttled range = %v with %v bytes, want 206 with 1024 bytes", w.Code, w.Body.Len())
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/zam-haus/dlsir/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+type fileStatus string
+
+const (
+	FileSucceeded fileStatus = "ok"
+	FileFailed    fileStatus = "failed"
+	FileMissing   fileStatus = "missing" // the phone didn't report a result
+)
+
+// fileResult is the result of a single file of a FileDeployment as reported by the phone
+type fileResult struct {
+	Index  int
+	Name   string
+	Status fileStatus
+	Detail string // status as sent by the phone
+}
+
+// What to do when the phone fails to deploy a file (file-failure-action)
+type fileFailureAction string
+
+const (
+	FileFailureContinue fileFailureAction = "continue" // log and continue with the next step
+	FileFailureRetry    fileFailureAction = "retry"    // deploy the failed files again, then continue
+	FileFailureAbort    fileFailureAction = "abort"    // skip the software update
+)
+
+const defaultFileRetries = 2
+
+var (
+	fileFailure = FileFailureRetry
+	fileRetries = defaultFileRetries
+)
+
+// configureFileFailures reads file-failure-action and file-retries from the server config
+func configureFileFailures(srvConf *config.ConfigFile) error {
+	if entry, err := srvConf.GetEntry("file-failure-action"); err == nil {
+		action := fileFailureAction(entry.Value)
+		if !slices.Contains([]fileFailureAction{FileFailureContinue, FileFailureRetry, FileFailureAbort}, action) {
+			return fmt.Errorf("invalid file-failure-action '%v', expected continue, retry or abort", entry.Value)
+		}
+		fileFailure = action
+	}
+
+	if entry, err := srvConf.GetEntry("file-retries"); err == nil {
+		retries, err := strconv.Atoi(entry.Value)
+		if err != nil || retries < 0 {
+			return fmt.Errorf("invalid file-retries '%v'", entry.Value)
+		}
+		fileRetries = retries
+	}
+
+	return nil
+}
+
+func parseFileStatus(value string) fileStatus {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "":
+		return FileMissing
+	case "ok", "success", "succeeded", "done":
+		return FileSucceeded
+	default:
+		return FileFailed
+	}
+}
+
+// parseFileResults reads the file-deployment-name and file-deployment-status items of a status
+// message. Files of pending the phone didn't report are FileMissing.
+func parseFileResults(items []item, pending []string) []fileResult {
+	res := make([]fileResult, 0)
+	reported := make(map[string]bool)
+
+	for _, nameItem := range items {
+		if nameItem.Name != "file-deployment-name" {
+			continue
+		}
+
+		// depending on the firmware, the phone reports the file name or the URL
+		result := fileResult{Index: nameItem.Index, Name: path.Base(nameItem.Value), Status: FileMissing}
+		if statusItem := findItem(items, "file-deployment-status", nameItem.Index); statusItem != nil {
+			result.Status = parseFileStatus(statusItem.Value)
+			result.Detail = statusItem.Value
+		}
+
+		reported[result.Name] = true
+		res = append(res, result)
+	}
+
+	// a status without name can't be assigned to a file
+	for _, statusItem := range items {
+		if statusItem.Name == "file-deployment-status" && findItem(items, "file-deployment-name", statusItem.Index) == nil {
+			res = append(res, fileResult{Index: statusItem.Index, Name: "?", Status: parseFileStatus(statusItem.Value), Detail: statusItem.Value})
+		}
+	}
+
+	for _, name := range pending {
+		if !reported[name] {
+			res = append(res, fileResult{Name: name, Status: FileMissing})
+		}
+	}
+
+	return res
+}
+
+// checkStatus evaluates the file deployment results of a status message, records them in the
+// inventory and returns the names of the files which failed
+func checkStatus(c *gin.Context, phone *phoneDesc, msg message) []string {
+	// firmware images are deployed with SoftwareDeployment and don't report a file status
+	pending := slices.DeleteFunc(slices.Clone(phone.PendingFiles), func(name string) bool {
+		return phone.FwTarget != nil && name == fileName(phone.FwTarget)
+	})
+	results := parseFileResults(msg.Items, pending)
+
+	failed := make([]string, 0)
+	for _, result := range results {
+		switch result.Status {
+		case FileSucceeded:
+			_log(c, "  - File '%v' deployed", result.Name)
+		case FileMissing:
+			_log(c, "  - WARNING: Phone didn't report the status of file '%v'", result.Name)
+		default:
+			_log(c, "  - WARNING: File '%v' failed: %v", result.Name, result.Detail)
+		}
+
+		if result.Status != FileSucceeded && result.Name != "?" {
+			failed = append(failed, result.Name)
+		}
+	}
+
+	inventory.FilesDeployed(phone, results, phone.FileRetries+1)
+	return failed
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/zam-haus/dlsir/internal/firmware"
+)
+
+func TestParseFileStatus(t *testing.T) {
+	tests := []struct {
+		value string
+		want  fileStatus
+	}{
+		{"ok", FileSucceeded},
+		{"OK", FileSucceeded},
+		{" success ", FileSucceeded},
+		{"Succeeded", FileSucceeded},
+		{"done", FileSucceeded},
+		{"", FileMissing},
+		{"  ", FileMissing},
+		{"failed", FileFailed},
+		{"file not found", FileFailed},
+		{"okay", FileFailed},
+	}
+
+	for _, tt := range tests {
+		if got := parseFileStatus(tt.value); got != tt.want {
+			t.Errorf("parseFileStatus(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestParseFileResults(t *testing.T) {
+	tests := []struct {
+		name    string
+		items   []item
+		pending []string
+		want    []fileResult
+	}{
+		{
+			name: "names and URLs",
+			items: []item{
+				{Name: "file-deployment-name", Index: 1, Value: "logo.bmp"},
+				{Name: "file-deployment-status", Index: 1, Value: "ok"},
+				{Name: "file-deployment-name", Index: 2, Value: "https://dls:18443/file/token/ringer.wav"},
+				{Name: "file-deployment-status", Index: 2, Value: "download failed"},
+			},
+			pending: []string{"logo.bmp", "ringer.wav"},
+			want: []fileResult{
+				{Index: 1, Name: "logo.bmp", Status: FileSucceeded, Detail: "ok"},
+				{Index: 2, Name: "ringer.wav", Status: FileFailed, Detail: "download failed"},
+			},
+		},
+		{
+			name: "status without name",
+			items: []item{
+				{Name: "file-deployment-name", Index: 1, Value: "logo.bmp"},
+				{Name: "file-deployment-status", Index: 1, Value: "ok"},
+				{Name: "file-deployment-status", Index: 2, Value: "failed"},
+			},
+			pending: []string{"logo.bmp"},
+			want: []fileResult{
+				{Index: 1, Name: "logo.bmp", Status: FileSucceeded, Detail: "ok"},
+				{Index: 2, Name: "?", Status: FileFailed, Detail: "failed"},
+			},
+		},
+		{
+			name: "missing status item",
+			items: []item{
+				{Name: "file-deployment-name", Index: 1, Value: "logo.bmp"},
+			},
+			pending: []string{"logo.bmp"},
+			want:    []fileResult{{Index: 1, Name: "logo.bmp", Status: FileMissing}},
+		},
+		{
+			name: "empty status",
+			items: []item{
+				{Name: "file-deployment-name", Index: 1, Value: "logo.bmp"},
+				{Name: "file-deployment-status", Index: 1},
+			},
+			pending: []string{"logo.bmp"},
+			want:    []fileResult{{Index: 1, Name: "logo.bmp", Status: FileMissing}},
+		},
+		{
+			name: "pending file never reported",
+			items: []item{
+				{Name: "reason", Value: "status"},
+				{Name: "file-deployment-name", Index: 1, Value: "logo.bmp"},
+				{Name: "file-deployment-status", Index: 1, Value: "done"},
+			},
+			pending: []string{"logo.bmp", "ringer.wav"},
+			want: []fileResult{
+				{Index: 1, Name: "logo.bmp", Status: FileSucceeded, Detail: "done"},
+				{Name: "ringer.wav", Status: FileMissing},
+			},
+		},
+		{
+			name:    "nothing reported",
+			pending: []string{"logo.bmp"},
+			want:    []fileResult{{Name: "logo.bmp", Status: FileMissing}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseFileResults(tt.items, tt.pending)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("parseFileResults = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckStatus(t *testing.T) {
+	inTempDir(t)
+
+	const mac = "00:1a:2b:3c:4d:99"
+	t.Cleanup(func() {
+		inventory.mu.Lock()
+		delete(inventory.Phones, mac)
+		inventory.mu.Unlock()
+	})
+
+	image := &firmware.FirmwareInfo{File: "files/os40_sip_v3.img"}
+	tests := []struct {
+		name     string
+		pending  []string
+		fwTarget *firmware.FirmwareInfo
+		items    []item
+		failed   []string
+		recorded int
+	}{
+		{
+			name:    "all deployed",
+			pending: []string{"logo.bmp"},
+			items: []item{
+				{Name: "file-deployment-name", Index: 1, Value: "logo.bmp"},
+				{Name: "file-deployment-status", Index: 1, Value: "ok"},
+			},
+			failed:   []string{},
+			recorded: 1,
+		},
+		{
+			name:    "failed and missing",
+			pending: []string{"logo.bmp", "ringer.wav"},
+			items: []item{
+				{Name: "file-deployment-name", Index: 1, Value: "logo.bmp"},
+				{Name: "file-deployment-status", Index: 1, Value: "failed"},
+			},
+			failed:   []string{"logo.bmp", "ringer.wav"},
+			recorded: 2,
+		},
+		{
+			// a status without name can't be retried
+			name:    "status without name",
+			pending: []string{},
+			items: []item{
+				{Name: "file-deployment-status", Index: 1, Value: "failed"},
+			},
+			failed:   []string{},
+			recorded: 1,
+		},
+		{
+			// the image is deployed with SoftwareDeployment and doesn't report a file status
+			name:     "firmware image",
+			pending:  []string{"logo.bmp", "os40_sip_v3.img"},
+			fwTarget: image,
+			items: []item{
+				{Name: "file-deployment-name", Index: 1, Value: "logo.bmp"},
+				{Name: "file-deployment-status", Index: 1, Value: "ok"},
+			},
+			failed:   []string{},
+			recorded: 1,
+		},
+		{
+			name:    "firmware image without target",
+			pending: []string{"logo.bmp", "os40_sip_v3.img"},
+			items: []item{
+				{Name: "file-deployment-name", Index: 1, Value: "logo.bmp"},
+				{Name: "file-deployment-status", Index: 1, Value: "ok"},
+			},
+			failed:   []string{"os40_sip_v3.img"},
+			recorded: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			phone := &phoneDesc{Mac: mac, PendingFiles: tt.pending, FwTarget: tt.fwTarget}
+			before := len(inventoryFiles(mac))
+
+			failed := checkStatus(nil, phone, message{Items: tt.items})
+			if !slices.Equal(failed, tt.failed) {
+				t.Errorf("checkStatus = %v, want %v", failed, tt.failed)
+			}
+			if !slices.Equal(phone.PendingFiles, tt.pending) {
+				t.Errorf("checkStatus modified PendingFiles to %v", phone.PendingFiles)
+			}
+
+			files := inventoryFiles(mac)
+			if got := len(files) - before; got != tt.recorded {
+				t.Errorf("%v results recorded in the inventory, want %v", got, tt.recorded)
+			}
+			for _, file := range files[before:] {
+				if file.Attempt != 1 {
+					t.Errorf("file %v recorded as attempt %v, want 1", file.Name, file.Attempt)
+				}
+			}
+		})
+	}
+}
+
+// inventoryFiles returns the recorded file deployments of a phone
+func inventoryFiles(mac string) []fileAttempt {
+	for _, p := range inventory.List() {
+		if p.Mac == mac {
+			return p.Files
+		}
+	}
+	return nil
+}
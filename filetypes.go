@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/zam-haus/dlsir/internal/config"
+	"github.com/zam-haus/dlsir/internal/media"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	priorityImmediate = "immediate"
+	priorityNormal    = "normal"
+)
+
+// fileTypeSpec describes a file-type the phones accept in a FileDeployment
+type fileTypeSpec struct {
+	// accepted source files (before conversion)
+	Extensions []string
+	// default file-priority
+	Priority string
+	// checks the content of the file as deployed (after conversion)
+	Validate func(content []byte) error
+}
+
+// Deployable file types by file-type value
+var fileTypes = map[string]fileTypeSpec{
+	"LOGO":        {Extensions: []string{".bmp", ".png"}, Priority: priorityNormal, Validate: validateBMP},
+	"SCREENSAVER": {Extensions: []string{".bmp", ".png"}, Priority: priorityNormal, Validate: validateBMP},
+	"RINGER":      {Extensions: []string{".wav", ".mp3", ".mid"}, Priority: priorityNormal, Validate: validateAudio},
+	"LDAP":        {Extensions: []string{".txt"}, Priority: priorityNormal, Validate: validateText},
+	// CA certificates the phone uses to verify servers (HTTPS, e.g. DLSir itself) and the RADIUS server (802.1X)
+	"HTTPS-CA":  {Extensions: []string{".pem", ".crt", ".cer", ".der"}, Priority: priorityImmediate, Validate: validateCACertificate},
+	"RADIUS-CA": {Extensions: []string{".pem", ".crt", ".cer", ".der"}, Priority: priorityImmediate, Validate: validateCACertificate},
+	// certificates with private key (PKCS#12) of the phone for 802.1X and its web server
+	"802.1X-CERT":     {Extensions: []string{".p12", ".pfx"}, Priority: priorityImmediate, Validate: validatePKCS12},
+	"WEB-SERVER-CERT": {Extensions: []string{".p12", ".pfx"}, Priority: priorityImmediate, Validate: validatePKCS12},
+}
+
+func validateBMP(content []byte) error {
+	width, height, err := media.BMPSize(bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	if width <= 0 || height <= 0 {
+		return fmt.Errorf("invalid BMP dimensions %vx%v", width, height)
+	}
+	return nil
+}
+
+func validateAudio(content []byte) error {
+	switch {
+	case bytes.HasPrefix(content, []byte("RIFF")):
+		_, _, err := media.DecodeWAV(bytes.NewReader(content))
+		return err
+	case bytes.HasPrefix(content, []byte("MThd")):
+		return nil
+	case bytes.HasPrefix(content, []byte("ID3")), len(content) > 1 && content[0] == 0xff && content[1]&0xe0 == 0xe0:
+		// ID3 tag or MPEG frame sync
+		return nil
+	}
+	return fmt.Errorf("not a WAV, MP3 or MIDI file")
+}
+
+func validateText(content []byte) error {
+	if len(content) == 0 {
+		return fmt.Errorf("file is empty")
+	}
+	if !utf8.Valid(content) || bytes.IndexByte(content, 0) != -1 {
+		return fmt.Errorf("not a text file")
+	}
+	return nil
+}
+
+// parseCertificates reads all certificates of a PEM or DER file
+func parseCertificates(content []byte) ([]*x509.Certificate, error) {
+	if !bytes.Contains(content, []byte("-----BEGIN")) {
+		return x509.ParseCertificates(content)
+	}
+
+	certs := make([]*x509.Certificate, 0)
+	for {
+		var block *pem.Block
+		block, content = pem.Decode(content)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("unexpected PEM block %v; only certificates may be deployed", block.Type)
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+func validateCACertificate(content []byte) error {
+	certs, err := parseCertificates(content)
+	if err != nil {
+		return fmt.Errorf("invalid certificate: %v", err)
+	}
+	if len(certs) == 0 {
+		return fmt.Errorf("file contains no certificate")
+	}
+	for _, cert := range certs {
+		if !cert.IsCA {
+			return fmt.Errorf("certificate %v is not a CA certificate", cert.Subject)
+		}
+	}
+	return nil
+}
+
+func validatePKCS12(content []byte) error {
+	// PKCS#12 files are a DER encoded ASN.1 SEQUENCE; they are encrypted, so that's all we can check
+	if len(content) < 2 || content[0] != 0x30 {
+		return fmt.Errorf("not a PKCS#12 file")
+	}
+	return nil
+}
+
+// prepareFile generates, converts and validates a file of a FileDeployment and returns the name
+// it is served as
+func prepareFile(c *gin.Context, phone *phoneDesc, conf *config.ConfigFile, name string, fileType string) (string, error) {
+	spec, ok := fileTypes[fileType]
+	if !ok {
+		if fileType == "APP" {
+			return "", fmt.Errorf("firmware is deployed from the firmware catalog, not as file")
+		}
+		return "", fmt.Errorf("unknown file-type '%v'", fileType)
+	}
+
+	if name == clientCertificateFile || name == caCertificateFile {
+		// client certificates are only valid for client authentication (802.1X), the CA is deployed as *-CA
+		if name == clientCertificateFile && fileType != "802.1X-CERT" || name == caCertificateFile && !strings.HasSuffix(fileType, "-CA") {
+			return "", fmt.Errorf("%v can't be deployed as file-type %v", name, fileType)
+		}
+
+		served, path, err := certificateFile(c, phone, name)
+		if err != nil {
+			return "", err
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		err = spec.Validate(content)
+		if err != nil {
+			return "", err
+		}
+
+		phone.Converted[served] = path
+		return served, nil
+	}
+
+	if generator, ok := strings.CutPrefix(name, generatedPrefix); ok {
+		if _, ok := imageFileTypes[fileType]; !ok {
+			return "", fmt.Errorf("%v generates an image, but file-type %v isn't", name, fileType)
+		}
+
+		file, err := generateFile(generator, phone, conf)
+		if err != nil {
+			return "", err
+		}
+		err = spec.Validate(file.Content)
+		if err != nil {
+			return "", err
+		}
+
+		name = generatedName(generator, phone)
+		phone.Generated[name] = file
+		return name, nil
+	}
+
+	if !slices.Contains(spec.Extensions, strings.ToLower(filepath.Ext(name))) {
+		return "", fmt.Errorf("file-type %v requires one of %v", fileType, strings.Join(spec.Extensions, ", "))
+	}
+
+	served, path, err := convertFile(c, name, fileType, phone, conf)
+	if err != nil {
+		return "", err
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	err = spec.Validate(content)
+	if err != nil {
+		return "", err
+	}
+
+	phone.Converted[served] = path
+	return served, nil
+}
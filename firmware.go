@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/zam-haus/dlsir/internal/config"
+	"github.com/zam-haus/dlsir/internal/firmware"
+
+	"github.com/gin-gonic/gin"
+)
+
+const filesDir = "./files/"
+
+var fwCatalog = firmware.NewCatalog(filesDir)
+
+// Prefix of the server config entries declaring the minimum source version of an image,
+// e.g. fw-min-source-os40_v3r5.img = V3 R3.0.0
+const fwMinSourcePrefix = "fw-min-source-"
+
+// refreshFirmwareCatalog rescans files/, logs all changed images and updates the declared upgrade paths
+// and whether images require a manifest
+func refreshFirmwareCatalog(srvConf *config.ConfigFile) {
+	minSources := make(map[string]firmware.FirmwareVersion)
+	for _, entry := range srvConf.GetFilteredEntries(fwMinSourcePrefix, true) {
+		version, err := firmware.ParseFirmwareVersion(entry.Value)
+		if err != nil {
+			_log(nil, "Ignoring %v: %v", entry.Name, err)
+			continue
+		}
+		minSources[strings.TrimPrefix(entry.Name, fwMinSourcePrefix)] = *version
+	}
+	fwCatalog.SetMinSources(minSources)
+
+	requireManifest, err := srvConf.GetEntry("fw-require-manifest")
+	fwCatalog.SetRequireManifest(err == nil && requireManifest.Value == "true")
+
+	changed, err := fwCatalog.Refresh()
+	if err != nil {
+		_log(nil, "Failed to refresh firmware catalog: %v", err)
+		return
+	}
+
+	for _, name := range changed {
+		info, err := fwCatalog.File(name)
+		if err != nil {
+			_log(nil, "Firmware catalog: %v is not usable: %v", name, err)
+			continue
+		}
+
+		stale := ""
+		if fwCatalog.IsStale(*info) {
+			stale = " [stale - newer version available]"
+		}
+		_log(nil, "Firmware catalog: %v: %v / %v, %v, %v%v", name, info.Phone, info.DevType, info.FwType, info.FwVersion, stale)
+	}
+}
+
+// Per-phone firmware selector and downgrade permission (phone config)
+const phoneFirmwareEntry = config.LocalPrefix + "firmware"
+const phoneAllowDowngradeEntry = config.LocalPrefix + "allow-downgrade"
+
+// firmwarePolicy describes which firmware a phone should run
+type firmwarePolicy struct {
+	// "latest", a version or a file name; see selectFirmware
+	Selector string
+	// config entry the selector was taken from
+	Source         string
+	AllowDowngrade bool
+	// the selected image
+	Target *firmware.FirmwareInfo
+}
+
+func (policy firmwarePolicy) String() string {
+	downgrade := "denied"
+	if policy.AllowDowngrade {
+		downgrade = "allowed"
+	}
+	return fmt.Sprintf("'%v' from %v, downgrades %v", policy.Selector, policy.Source, downgrade)
+}
+
+// NeedsUpdate returns whether a phone running version must be updated (or downgraded)
+func (policy firmwarePolicy) NeedsUpdate(version firmware.FirmwareVersion) bool {
+	cmp := version.Compare(policy.Target.FwVersion)
+	return cmp < 0 || (cmp > 0 && policy.AllowDowngrade)
+}
+
+// configLookup references an entry of a config file
+type configLookup struct {
+	conf *config.ConfigFile
+	name string
+}
+
+// firstEntry returns the first existing entry of lookups
+func firstEntry(lookups []configLookup) (config.ConfigEntry, bool) {
+	for _, lookup := range lookups {
+		entry, err := lookup.conf.GetEntry(lookup.name)
+		if err == nil {
+			return entry, true
+		}
+	}
+	return config.ConfigEntry{}, false
+}
+
+// getFirmwarePolicy selects the target firmware of a phone. The selector is taken from (in this order)
+//   - dlsir-firmware in the phone config,
+//   - fw-group-<group> in the server config for the first group of the phone with such an entry,
+//   - fw-<devicetype> in the server config.
+//
+// Downgrades are allowed by dlsir-allow-downgrade in the phone config, fw-allow-downgrade-<group>
+// or fw-allow-downgrade in the server config (in this order).
+func getFirmwarePolicy(srvConf *config.ConfigFile, phoneConf *config.ConfigFile, devType string, fwType string) (*firmwarePolicy, error) {
+	groups := phoneConf.GetGroups()
+
+	selectors := []configLookup{{phoneConf, phoneFirmwareEntry}}
+	downgrades := []configLookup{{phoneConf, phoneAllowDowngradeEntry}}
+	for _, group := range groups {
+		selectors = append(selectors, configLookup{srvConf, "fw-group-" + group})
+		downgrades = append(downgrades, configLookup{srvConf, "fw-allow-downgrade-" + group})
+	}
+	selectors = append(selectors, configLookup{srvConf, config.GetFwItemName(devType)})
+	downgrades = append(downgrades, configLookup{srvConf, "fw-allow-downgrade"})
+
+	selector, ok := firstEntry(selectors)
+	if !ok {
+		return nil, fmt.Errorf("no firmware configured for %v (configure as %v)", devType, config.GetFwItemName(devType))
+	}
+
+	policy := firmwarePolicy{Selector: selector.Value, Source: selector.Name}
+	if downgrade, ok := firstEntry(downgrades); ok {
+		policy.AllowDowngrade = downgrade.Value == "true"
+	}
+
+	target, err := selectFirmware(policy.Selector, devType, fwType)
+	if err != nil {
+		return nil, fmt.Errorf("firmware %v: %v", policy, err)
+	}
+	policy.Target = target
+
+	return &policy, nil
+}
+
+// selectFirmware resolves "latest", a version or a file name to an image of the catalog
+func selectFirmware(selector string, devType string, fwType string) (*firmware.FirmwareInfo, error) {
+	if selector == "latest" {
+		return fwCatalog.Latest(devType, fwType)
+	}
+
+	if filepath.Ext(selector) == firmware.ImageExtension {
+		return fwCatalog.File(selector)
+	}
+
+	version, err := firmware.ParseFirmwareVersion(selector)
+	if err != nil {
+		return nil, fmt.Errorf("'%v' is neither 'latest', a firmware version nor an image file", selector)
+	}
+
+	return fwCatalog.Version(devType, fwType, *version)
+}
+
+// planUpgrade selects the next image to deploy to the phone on its way to phone.FwFinal.
+// Downgrades and updates without declared minimum source versions deploy FwFinal directly.
+func planUpgrade(c *gin.Context, phone *phoneDesc) error {
+	phone.FwTarget = phone.FwFinal
+	if phone.FwVersion.Compare(phone.FwFinal.FwVersion) >= 0 {
+		return nil
+	}
+
+	path, err := fwCatalog.UpgradePath(phone.DevType, phone.FwType, phone.FwVersion, *phone.FwFinal)
+	if err != nil {
+		return err
+	}
+
+	if len(path) > 1 {
+		hops := make([]string, 0, len(path))
+		for _, hop := range path {
+			hops = append(hops, hop.FwVersion.String())
+		}
+		_log(c, "Upgrade path: %v -> %v", phone.FwVersion, strings.Join(hops, " -> "))
+	}
+	phone.FwTarget = &path[0]
+
+	return nil
+}
+
+// fileName returns the name of the image relative to files/
+func fileName(info *firmware.FirmwareInfo) string {
+	return filepath.Base(info.File)
+}
+
+// verifyUpdate checks the version reported by a phone which came back after a software deployment
+func verifyUpdate(c *gin.Context, phone *phoneDesc, msg message) {
+	var version *firmware.FirmwareVersion
+	note := ""
+
+	reported := itemByName(msg.Items, "software-version")
+	if reported == nil {
+		note = "phone did not report its software version"
+	} else {
+		ver, err := firmware.ParseFirmwareVersion(*reported)
+		if err != nil {
+			note = fmt.Sprintf("failed to parse reported software version: %v", err)
+		} else {
+			version = ver
+		}
+	}
+
+	attempt := inventory.UpdateFinished(phone, version, note)
+	logUpdateAttempt(c, phone, attempt)
+	rollouts.PhoneReturned(phone, attempt)
+
+	if version != nil {
+		phone.FwVersion = *version
+	}
+}
+
+func logUpdateAttempt(c *gin.Context, phone *phoneDesc, attempt updateAttempt) {
+	if attempt.Result == UpdateSucceeded {
+		_log(c, "Software update of phone %v from %v to %v succeeded", phone.Number, attempt.From, attempt.To)
+	} else {
+		_log(c, "WARNING: Software update of phone %v from %v to %v %v: %v", phone.Number, attempt.From, attempt.To, attempt.Result, attempt.Note)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/zam-haus/dlsir/internal/firmware"
+)
+
+type inspectResult struct {
+	File       string   `json:"file"`
+	Phone      string   `json:"phone,omitempty"`
+	DevType    string   `json:"device-type,omitempty"`
+	FwType     string   `json:"firmware-type,omitempty"`
+	Version    string   `json:"version,omitempty"`
+	Manifest   bool     `json:"manifest"`
+	Deployable bool     `json:"deployable"`
+	Problems   []string `json:"problems"`
+}
+
+// inspectFirmware parses an image and checks whether DLSir can deploy it
+func inspectFirmware(file string) inspectResult {
+	res := inspectResult{File: file, Problems: make([]string, 0)}
+
+	info, err := firmware.GetFirmwareInfo(file)
+	if err != nil {
+		res.Problems = append(res.Problems, fmt.Sprintf("not a valid firmware image: %v", err))
+		return res
+	}
+
+	res.Phone = info.Phone
+	res.DevType = info.DevType
+	res.FwType = info.FwType
+	res.Version = info.FwVersion.String()
+
+	if filepath.Ext(file) != firmware.ImageExtension {
+		res.Problems = append(res.Problems, fmt.Sprintf("file name must end with %v to be found in the firmware catalog", firmware.ImageExtension))
+	}
+
+	if _, err := os.Stat(firmware.ManifestFile(file)); err == nil {
+		res.Manifest = true
+		manifest, err := firmware.LoadManifest(firmware.ManifestFile(file))
+		if err == nil {
+			err = manifest.Verify(*info)
+		}
+		if err != nil {
+			res.Problems = append(res.Problems, fmt.Sprintf("manifest check failed: %v", err))
+		}
+	}
+
+	absFile, errFile := filepath.Abs(file)
+	absDir, errDir := filepath.Abs(filesDir)
+	if errFile != nil || errDir != nil || filepath.Dir(absFile) != absDir {
+		res.Problems = append(res.Problems, fmt.Sprintf("image is not stored in %v and can't be served to phones", filesDir))
+	}
+
+	res.Deployable = len(res.Problems) == 0
+	return res
+}
+
+func printInspectResult(res inspectResult) {
+	fmt.Printf("%v:\n", res.File)
+	if res.Version != "" {
+		fmt.Printf("  phone model:   %v\n", res.Phone)
+		fmt.Printf("  device type:   %v\n", res.DevType)
+		fmt.Printf("  firmware type: %v\n", res.FwType)
+		fmt.Printf("  version:       %v\n", res.Version)
+		fmt.Printf("  manifest:      %v\n", res.Manifest)
+	}
+	fmt.Printf("  deployable:    %v\n", res.Deployable)
+	for _, problem := range res.Problems {
+		fmt.Printf("  - %v\n", problem)
+	}
+}
+
+func runFirmwareInspect(args []string) int {
+	flags := flag.NewFlagSet("firmware inspect", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the results as JSON")
+	_ = flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		return firmwareUsage()
+	}
+
+	results := make([]inspectResult, 0)
+	allDeployable := true
+	for _, file := range flags.Args() {
+		res := inspectFirmware(file)
+		results = append(results, res)
+		allDeployable = allDeployable && res.Deployable
+	}
+
+	if *asJSON {
+		out, _ := json.MarshalIndent(results, "", "  ")
+		fmt.Println(string(out))
+	} else {
+		for _, res := range results {
+			printInspectResult(res)
+		}
+	}
+
+	if !allDeployable {
+		return 1
+	}
+	return 0
+}
+
+// runFirmwareManifest writes the sidecar manifest of each image
+func runFirmwareManifest(args []string) int {
+	flags := flag.NewFlagSet("firmware manifest", flag.ExitOnError)
+	force := flags.Bool("force", false, "overwrite existing manifests")
+	_ = flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		return firmwareUsage()
+	}
+
+	res := 0
+	for _, file := range flags.Args() {
+		manifestFile := firmware.ManifestFile(file)
+		if _, err := os.Stat(manifestFile); err == nil && !*force {
+			fmt.Fprintf(os.Stderr, "%v already exists; use -force to overwrite it\n", manifestFile)
+			res = 1
+			continue
+		}
+
+		info, err := firmware.GetFirmwareInfo(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v is not a valid firmware image: %v\n", file, err)
+			res = 1
+			continue
+		}
+
+		manifest, err := firmware.NewManifest(*info)
+		if err == nil {
+			err = manifest.Write(manifestFile)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			res = 1
+			continue
+		}
+
+		fmt.Printf("%v: %v, %v, %v, %v bytes, SHA-256 %v\n", manifestFile, manifest.DevType, manifest.FwType, manifest.Version, manifest.Size, manifest.SHA256)
+	}
+
+	return res
+}
+
+func firmwareUsage() int {
+	fmt.Fprintf(os.Stderr, "Usage: %v firmware <inspect|manifest> ...\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  firmware inspect [-json] <file...>\n")
+	fmt.Fprintf(os.Stderr, "  firmware manifest [-force] <file...>\n")
+	return 2
+}
+
+func runFirmware(args []string) int {
+	if len(args) == 0 {
+		return firmwareUsage()
+	}
+
+	switch args[0] {
+	case "inspect":
+		return runFirmwareInspect(args[1:])
+	case "manifest":
+		return runFirmwareManifest(args[1:])
+	default:
+		return firmwareUsage()
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/zam-haus/dlsir/internal/bitmap"
+	"github.com/zam-haus/dlsir/internal/config"
+	"github.com/zam-haus/dlsir/internal/firmware"
+)
+
+// file-name values starting with this prefix refer to a generator instead of a file in files/,
+// e.g. file-name[1] = @logo
+const generatedPrefix = "@"
+
+type imageSize struct {
+	Width  int
+	Height int
+}
+
+func (size imageSize) String() string {
+	return fmt.Sprintf("%vx%v", size.Width, size.Height)
+}
+
+func parseImageSize(text string) (imageSize, error) {
+	var size imageSize
+	_, err := fmt.Sscanf(text, "%dx%d", &size.Width, &size.Height)
+	if err != nil || size.Width <= 0 || size.Height <= 0 || size.Width > 4096 || size.Height > 4096 {
+		return imageSize{}, fmt.Errorf("invalid size '%v', expected WIDTHxHEIGHT", text)
+	}
+	return size, nil
+}
+
+// generator renders a per-phone image
+type generator struct {
+	// keyed by GetDeviceKey(device-type); can be overridden by dlsir-<generator>-size
+	Sizes map[string]imageSize
+	// default text template; lines are separated by '|'
+	Text string
+}
+
+// The default sizes are best guesses; set dlsir-<generator>-size if the phone rejects the image
+var generators = map[string]generator{
+	"logo": {
+		Sizes: map[string]imageSize{
+			"openstage40": {144, 32},
+			"openstage60": {240, 60},
+			"openstage80": {480, 120},
+		},
+		Text: "{display-id-unicode}|{number}",
+	},
+	"screensaver": {
+		Sizes: map[string]imageSize{
+			"openstage60": {320, 240},
+			"openstage80": {640, 480},
+		},
+		Text: "{display-id-unicode}|{number}",
+	},
+}
+
+// generatedFile is a file rendered for a single phone and served from memory
+type generatedFile struct {
+	Content []byte
+	ModTime time.Time
+}
+
+var placeholderRx = regexp.MustCompile(`\{([a-z0-9-]+)\}`)
+
+// expandPlaceholders replaces {entry} by the value of the phone config entry; {number}, {mac} and
+// {device-type} refer to the phone itself
+func expandPlaceholders(text string, phone *phoneDesc, conf *config.ConfigFile) string {
+	return placeholderRx.ReplaceAllStringFunc(text, func(placeholder string) string {
+		name := placeholder[1 : len(placeholder)-1]
+		switch name {
+		case "number":
+			return phone.Number
+		case "mac":
+			return phone.Mac
+		case "device-type":
+			return phone.DevType
+		}
+
+		entry, err := conf.GetEntry(name)
+		if err != nil {
+			return ""
+		}
+		return entry.Value
+	})
+}
+
+// generatedName returns the name a generated file is served as, unique per phone
+func generatedName(name string, phone *phoneDesc) string {
+	return fmt.Sprintf("%v-%v.bmp", name, strings.ReplaceAll(phone.Mac, ":", ""))
+}
+
+// imageOption returns dlsir-<kind>-<option> from the phone config (or def)
+func imageOption(conf *config.ConfigFile, kind string, option string, def string) string {
+	entry, err := conf.GetEntry(config.LocalPrefix + kind + "-" + option)
+	if err != nil {
+		return def
+	}
+	return entry.Value
+}
+
+// imageSizeFor returns the resolution of a logo or screensaver on the phone
+func imageSizeFor(kind string, phone *phoneDesc, conf *config.ConfigFile) (imageSize, error) {
+	if text := imageOption(conf, kind, "size", ""); text != "" {
+		return parseImageSize(text)
+	}
+
+	size, ok := generators[kind].Sizes[firmware.DeviceKey(phone.DevType)]
+	if !ok {
+		return imageSize{}, fmt.Errorf("no %v size known for %v; configure %v%v-size", kind, phone.DevType, config.LocalPrefix, kind)
+	}
+	return size, nil
+}
+
+// generateFile renders the file of the named generator for the phone; its options are read
+// from dlsir-<generator>-size, -text, -foreground and -background in the phone config
+func generateFile(name string, phone *phoneDesc, conf *config.ConfigFile) (*generatedFile, error) {
+	gen, ok := generators[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown generator '%v'", name)
+	}
+
+	size, err := imageSizeFor(name, phone, conf)
+	if err != nil {
+		return nil, err
+	}
+
+	foreground, err := bitmap.ParseColor(imageOption(conf, name, "foreground", "000000"))
+	if err != nil {
+		return nil, err
+	}
+	background, err := bitmap.ParseColor(imageOption(conf, name, "background", "ffffff"))
+	if err != nil {
+		return nil, err
+	}
+
+	lines := make([]string, 0)
+	for _, line := range strings.Split(expandPlaceholders(imageOption(conf, name, "text", gen.Text), phone, conf), "|") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	img := bitmap.New(size.Width, size.Height, background)
+	img.DrawCentered(lines, foreground)
+
+	var buf bytes.Buffer
+	err = img.EncodeBMP(&buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return &generatedFile{Content: buf.Bytes(), ModTime: time.Now()}, nil
+}
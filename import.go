@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/zam-haus/dlsir/internal/config"
+)
+
+// CSV columns with a special meaning; all other columns are taken as config items
+const (
+	colMac       = "mac"
+	colExtension = "extension"
+	colName      = "name"
+	colHostname  = "hostname"
+	colGroups    = "groups"
+)
+
+type importRow struct {
+	mac      string
+	hostname string
+	entries  []config.ConfigEntry
+}
+
+func parseImportRow(header []string, record []string) (*importRow, error) {
+	row := importRow{entries: make([]config.ConfigEntry, 0)}
+
+	for idx, col := range header {
+		value := strings.TrimSpace(record[idx])
+		if value == "" {
+			// empty cells leave the existing entry untouched
+			continue
+		}
+
+		switch strings.ToLower(col) {
+		case colMac:
+			hw, err := net.ParseMAC(value)
+			if err != nil || len(hw) != 6 {
+				return nil, fmt.Errorf("invalid MAC address '%v'", value)
+			}
+			// phone configs are named like the phone reports its mac-addr: 00:1a:2b:3c:4d:5e
+			row.mac = hw.String()
+		case colExtension:
+			row.entries = append(row.entries,
+				config.ConfigEntry{Name: "e164", Value: value},
+				config.ConfigEntry{Name: "basic-e164", Value: value},
+				config.ConfigEntry{Name: "sip-user-id", Value: value})
+		case colName:
+			row.entries = append(row.entries, config.ConfigEntry{Name: "display-id-unicode", Value: value})
+		case colHostname:
+			row.hostname = value
+			row.entries = append(row.entries, config.ConfigEntry{Name: "hostname", Value: value})
+		case colGroups:
+			// accept both ',' and ' ' as separator within the cell
+			groups := config.SplitList(strings.ReplaceAll(value, " ", ","))
+			row.entries = append(row.entries, config.ConfigEntry{Name: config.GroupsEntry, Value: strings.Join(groups, ",")})
+		default:
+			name, index, err := config.ParseKey(col)
+			if err != nil {
+				return nil, err
+			}
+			row.entries = append(row.entries, config.ConfigEntry{Name: name, Index: index, Value: value})
+		}
+	}
+
+	if row.mac == "" {
+		return nil, fmt.Errorf("missing MAC address")
+	}
+
+	return &row, nil
+}
+
+func addManagedPhones(hostnames []string, dryRun bool) ([]config.EntryChange, error) {
+	conf, err := config.GetConfigFile(confSrv)
+	if err != nil {
+		return nil, err
+	}
+
+	managed := conf.GetFilteredEntries("managed-phones", true)
+	nextIndex := 0
+	for _, entry := range managed {
+		index, err := strconv.Atoi(entry.Index)
+		if err == nil && index >= nextIndex {
+			nextIndex = index + 1
+		}
+	}
+
+	known := make(map[string]bool)
+	for _, entry := range managed {
+		known[strings.ToLower(entry.Value)] = true
+	}
+
+	newEntries := make([]config.ConfigEntry, 0)
+	for _, hostname := range hostnames {
+		if !known[strings.ToLower(hostname)] {
+			known[strings.ToLower(hostname)] = true
+			newEntries = append(newEntries, config.ConfigEntry{Name: "managed-phones", Index: strconv.Itoa(nextIndex), Value: hostname})
+			nextIndex++
+		}
+	}
+
+	return config.UpdateConfigFile(confSrv, newEntries, dryRun)
+}
+
+func printChanges(file string, changes []config.EntryChange) {
+	if len(changes) == 0 {
+		return
+	}
+
+	fmt.Printf("--- %v\n+++ %v\n", file, file)
+	for _, change := range changes {
+		fmt.Println(change)
+	}
+}
+
+func importPhones(reader io.Reader, delimiter rune, dryRun bool) error {
+	csvReader := csv.NewReader(reader)
+	csvReader.Comma = delimiter
+	csvReader.TrimLeadingSpace = true
+
+	header, err := csvReader.Read()
+	if err != nil {
+		return fmt.Errorf("failed to read CSV header: %v", err)
+	}
+
+	hostnames := make([]string, 0)
+
+	for lineNo := 2; ; lineNo++ {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read CSV: %v", err)
+		}
+
+		row, err := parseImportRow(header, record)
+		if err != nil {
+			return fmt.Errorf("line #%v: %v", lineNo, err)
+		}
+
+		file := confDir + "/" + row.mac + ".conf"
+		changes, err := config.UpdateConfigFile(file, row.entries, dryRun)
+		if err != nil {
+			return err
+		}
+		printChanges(file, changes)
+
+		if row.hostname != "" {
+			hostnames = append(hostnames, row.hostname)
+		}
+	}
+
+	changes, err := addManagedPhones(hostnames, dryRun)
+	if err != nil {
+		return err
+	}
+	printChanges(confSrv, changes)
+
+	return nil
+}
+
+func runImport(args []string) int {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "only show the changes, don't write any files")
+	delimiter := flags.String("delimiter", ",", "field delimiter of the CSV file")
+	_ = flags.Parse(args)
+
+	if flags.NArg() != 1 || len([]rune(*delimiter)) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %v import [options] <file.csv>\n", os.Args[0])
+		flags.PrintDefaults()
+		return 2
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open %v: %v\n", flags.Arg(0), err)
+		return 1
+	}
+	defer f.Close()
+
+	err = importPhones(f, []rune(*delimiter)[0], *dryRun)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Import failed: %v\n", err)
+		return 1
+	}
+
+	return 0
+}
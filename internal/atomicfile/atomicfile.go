@@ -0,0 +1,37 @@
+// Package atomicfile replaces files atomically, so readers never see a partially written file.
+package atomicfile
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// WriteFile replaces file with content, creating its directory if required
+func WriteFile(file string, content []byte, perm os.FileMode) error {
+	dir := filepath.Dir(file)
+	err := os.MkdirAll(dir, 0777)
+	if err != nil {
+		return err
+	}
+
+	// a unique temporary file, so concurrent writers don't clobber each other's content
+	tmp, err := os.CreateTemp(dir, filepath.Base(file)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(content)
+	if err == nil {
+		// CreateTemp uses 0600
+		err = tmp.Chmod(perm)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), file)
+}
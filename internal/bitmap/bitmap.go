@@ -0,0 +1,125 @@
+package bitmap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+type Color struct {
+	R, G, B uint8
+}
+
+var (
+	Black = Color{0, 0, 0}
+	White = Color{255, 255, 255}
+)
+
+func (c Color) String() string {
+	return fmt.Sprintf("%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// ParseColor parses colors like "ff8000" or "#ff8000"
+func ParseColor(text string) (Color, error) {
+	if len(text) == 7 && text[0] == '#' {
+		text = text[1:]
+	}
+	value, err := strconv.ParseUint(text, 16, 32)
+	if err != nil || len(text) != 6 {
+		return Color{}, fmt.Errorf("invalid color '%v', expected RRGGBB", text)
+	}
+	return Color{uint8(value >> 16), uint8(value >> 8), uint8(value)}, nil
+}
+
+// Image is a simple RGB raster image
+type Image struct {
+	Width  int
+	Height int
+	Pix    []Color
+}
+
+// New returns an image filled with the background color
+func New(width int, height int, background Color) *Image {
+	img := &Image{Width: width, Height: height, Pix: make([]Color, width*height)}
+	for idx := range img.Pix {
+		img.Pix[idx] = background
+	}
+	return img
+}
+
+// Set colors a pixel; pixels outside of the image are ignored
+func (img *Image) Set(x int, y int, c Color) {
+	if x < 0 || y < 0 || x >= img.Width || y >= img.Height {
+		return
+	}
+	img.Pix[y*img.Width+x] = c
+}
+
+func (img *Image) At(x int, y int) Color {
+	return img.Pix[y*img.Width+x]
+}
+
+// FillRect colors the rectangle with the upper left corner x, y
+func (img *Image) FillRect(x int, y int, width int, height int, c Color) {
+	for dy := 0; dy < height; dy++ {
+		for dx := 0; dx < width; dx++ {
+			img.Set(x+dx, y+dy, c)
+		}
+	}
+}
+
+const (
+	bmpFileHeaderSize = 14
+	bmpInfoHeaderSize = 40
+)
+
+// EncodeBMP writes the image as uncompressed 24 bit Windows bitmap
+func (img *Image) EncodeBMP(w io.Writer) error {
+	// rows are padded to a multiple of 4 bytes
+	rowSize := (img.Width*3 + 3) &^ 3
+	dataSize := rowSize * img.Height
+	offset := bmpFileHeaderSize + bmpInfoHeaderSize
+
+	header := []any{
+		// BITMAPFILEHEADER
+		[2]byte{'B', 'M'},
+		uint32(offset + dataSize),
+		uint32(0),
+		uint32(offset),
+		// BITMAPINFOHEADER
+		uint32(bmpInfoHeaderSize),
+		int32(img.Width),
+		int32(img.Height), // positive: rows are stored bottom-up
+		uint16(1),         // planes
+		uint16(24),        // bits per pixel
+		uint32(0),         // BI_RGB, uncompressed
+		uint32(dataSize),
+		int32(2835), // 72 dpi
+		int32(2835),
+		uint32(0), // colors in palette
+		uint32(0), // important colors
+	}
+	for _, field := range header {
+		err := binary.Write(w, binary.LittleEndian, field)
+		if err != nil {
+			return err
+		}
+	}
+
+	row := make([]byte, rowSize)
+	for y := img.Height - 1; y >= 0; y-- {
+		for x := 0; x < img.Width; x++ {
+			c := img.At(x, y)
+			row[x*3] = c.B
+			row[x*3+1] = c.G
+			row[x*3+2] = c.R
+		}
+		_, err := w.Write(row)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,147 @@
+package bitmap
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Glyphs of the built-in font are 5x7 pixels; with spacing, every character takes 6x8 pixels
+const (
+	GlyphWidth  = 5
+	GlyphHeight = 7
+	CellWidth   = GlyphWidth + 1
+	CellHeight  = GlyphHeight + 1
+)
+
+// Rows of each glyph, top to bottom; bit 4 is the leftmost pixel.
+// Only upper case letters exist; lower case text is rendered in upper case.
+var glyphs = map[rune][GlyphHeight]uint8{
+	' ':  {},
+	'A':  {0b01110, 0b10001, 0b10001, 0b11111, 0b10001, 0b10001, 0b10001},
+	'B':  {0b11110, 0b10001, 0b10001, 0b11110, 0b10001, 0b10001, 0b11110},
+	'C':  {0b01110, 0b10001, 0b10000, 0b10000, 0b10000, 0b10001, 0b01110},
+	'D':  {0b11100, 0b10010, 0b10001, 0b10001, 0b10001, 0b10010, 0b11100},
+	'E':  {0b11111, 0b10000, 0b10000, 0b11110, 0b10000, 0b10000, 0b11111},
+	'F':  {0b11111, 0b10000, 0b10000, 0b11110, 0b10000, 0b10000, 0b10000},
+	'G':  {0b01110, 0b10001, 0b10000, 0b10111, 0b10001, 0b10001, 0b01111},
+	'H':  {0b10001, 0b10001, 0b10001, 0b11111, 0b10001, 0b10001, 0b10001},
+	'I':  {0b01110, 0b00100, 0b00100, 0b00100, 0b00100, 0b00100, 0b01110},
+	'J':  {0b00111, 0b00010, 0b00010, 0b00010, 0b00010, 0b10010, 0b01100},
+	'K':  {0b10001, 0b10010, 0b10100, 0b11000, 0b10100, 0b10010, 0b10001},
+	'L':  {0b10000, 0b10000, 0b10000, 0b10000, 0b10000, 0b10000, 0b11111},
+	'M':  {0b10001, 0b11011, 0b10101, 0b10101, 0b10001, 0b10001, 0b10001},
+	'N':  {0b10001, 0b10001, 0b11001, 0b10101, 0b10011, 0b10001, 0b10001},
+	'O':  {0b01110, 0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b01110},
+	'P':  {0b11110, 0b10001, 0b10001, 0b11110, 0b10000, 0b10000, 0b10000},
+	'Q':  {0b01110, 0b10001, 0b10001, 0b10001, 0b10101, 0b10010, 0b01101},
+	'R':  {0b11110, 0b10001, 0b10001, 0b11110, 0b10100, 0b10010, 0b10001},
+	'S':  {0b01111, 0b10000, 0b10000, 0b01110, 0b00001, 0b00001, 0b11110},
+	'T':  {0b11111, 0b00100, 0b00100, 0b00100, 0b00100, 0b00100, 0b00100},
+	'U':  {0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b01110},
+	'V':  {0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b01010, 0b00100},
+	'W':  {0b10001, 0b10001, 0b10001, 0b10101, 0b10101, 0b10101, 0b01010},
+	'X':  {0b10001, 0b10001, 0b01010, 0b00100, 0b01010, 0b10001, 0b10001},
+	'Y':  {0b10001, 0b10001, 0b10001, 0b01010, 0b00100, 0b00100, 0b00100},
+	'Z':  {0b11111, 0b00001, 0b00010, 0b00100, 0b01000, 0b10000, 0b11111},
+	'0':  {0b01110, 0b10001, 0b10011, 0b10101, 0b11001, 0b10001, 0b01110},
+	'1':  {0b00100, 0b01100, 0b00100, 0b00100, 0b00100, 0b00100, 0b01110},
+	'2':  {0b01110, 0b10001, 0b00001, 0b00010, 0b00100, 0b01000, 0b11111},
+	'3':  {0b11111, 0b00010, 0b00100, 0b00010, 0b00001, 0b10001, 0b01110},
+	'4':  {0b00010, 0b00110, 0b01010, 0b10010, 0b11111, 0b00010, 0b00010},
+	'5':  {0b11111, 0b10000, 0b11110, 0b00001, 0b00001, 0b10001, 0b01110},
+	'6':  {0b00110, 0b01000, 0b10000, 0b11110, 0b10001, 0b10001, 0b01110},
+	'7':  {0b11111, 0b00001, 0b00010, 0b00100, 0b01000, 0b01000, 0b01000},
+	'8':  {0b01110, 0b10001, 0b10001, 0b01110, 0b10001, 0b10001, 0b01110},
+	'9':  {0b01110, 0b10001, 0b10001, 0b01111, 0b00001, 0b00010, 0b01100},
+	'-':  {0b00000, 0b00000, 0b00000, 0b11111, 0b00000, 0b00000, 0b00000},
+	'.':  {0b00000, 0b00000, 0b00000, 0b00000, 0b00000, 0b01100, 0b01100},
+	',':  {0b00000, 0b00000, 0b00000, 0b00000, 0b01100, 0b00100, 0b01000},
+	':':  {0b00000, 0b01100, 0b01100, 0b00000, 0b01100, 0b01100, 0b00000},
+	'/':  {0b00000, 0b00001, 0b00010, 0b00100, 0b01000, 0b10000, 0b00000},
+	'(':  {0b00010, 0b00100, 0b01000, 0b01000, 0b01000, 0b00100, 0b00010},
+	')':  {0b01000, 0b00100, 0b00010, 0b00010, 0b00010, 0b00100, 0b01000},
+	'+':  {0b00000, 0b00100, 0b00100, 0b11111, 0b00100, 0b00100, 0b00000},
+	'#':  {0b01010, 0b01010, 0b11111, 0b01010, 0b11111, 0b01010, 0b01010},
+	'_':  {0b00000, 0b00000, 0b00000, 0b00000, 0b00000, 0b00000, 0b11111},
+	'\'': {0b01100, 0b00100, 0b01000, 0b00000, 0b00000, 0b00000, 0b00000},
+	'&':  {0b01100, 0b10010, 0b10100, 0b01000, 0b10101, 0b10010, 0b01101},
+	'@':  {0b01110, 0b10001, 0b00001, 0b01101, 0b10101, 0b10101, 0b01110},
+	'?':  {0b01110, 0b10001, 0b00001, 0b00010, 0b00100, 0b00000, 0b00100},
+	'!':  {0b00100, 0b00100, 0b00100, 0b00100, 0b00100, 0b00000, 0b00100},
+	'*':  {0b00000, 0b00100, 0b10101, 0b01110, 0b10101, 0b00100, 0b00000},
+}
+
+// Letters without glyph which are rendered as their base letter
+var foldedRunes = map[rune]string{
+	'Ä': "A", 'Ö': "O", 'Ü': "U", 'ß': "SS", 'É': "E", 'È': "E", 'À': "A", 'Ç': "C",
+}
+
+// normalizeText maps text to the characters of the font; unknown characters become '?'
+func normalizeText(text string) string {
+	var sb strings.Builder
+	for _, r := range strings.ToUpper(text) {
+		if folded, ok := foldedRunes[r]; ok {
+			sb.WriteString(folded)
+		} else if _, ok := glyphs[r]; ok {
+			sb.WriteRune(r)
+		} else if unicode.IsSpace(r) {
+			sb.WriteRune(' ')
+		} else {
+			sb.WriteRune('?')
+		}
+	}
+	return sb.String()
+}
+
+// TextWidth returns the width of text in pixels when drawn with the given scale
+func TextWidth(text string, scale int) int {
+	n := len([]rune(normalizeText(text)))
+	if n == 0 {
+		return 0
+	}
+	// no spacing after the last character
+	return (n*CellWidth - 1) * scale
+}
+
+// DrawText draws text with its upper left corner at x, y; every font pixel becomes scale x scale pixels
+func (img *Image) DrawText(x int, y int, text string, scale int, c Color) {
+	for idx, r := range []rune(normalizeText(text)) {
+		glyph := glyphs[r]
+		left := x + idx*CellWidth*scale
+		for row := 0; row < GlyphHeight; row++ {
+			for col := 0; col < GlyphWidth; col++ {
+				if glyph[row]&(1<<(GlyphWidth-1-col)) != 0 {
+					img.FillRect(left+col*scale, y+row*scale, scale, scale, c)
+				}
+			}
+		}
+	}
+}
+
+// DrawCentered draws the lines of text centered in the image, using the largest scale at which
+// all lines fit (at least 1; text too large even then is clipped)
+func (img *Image) DrawCentered(lines []string, c Color) {
+	if len(lines) == 0 {
+		return
+	}
+
+	scale := 1
+	for s := 2; ; s++ {
+		fits := len(lines)*CellHeight*s-s <= img.Height
+		for _, line := range lines {
+			fits = fits && TextWidth(line, s) <= img.Width
+		}
+		if !fits {
+			break
+		}
+		scale = s
+	}
+
+	// no spacing below the last line
+	height := len(lines)*CellHeight*scale - scale
+	top := (img.Height - height) / 2
+	for idx, line := range lines {
+		left := (img.Width - TextWidth(line, scale)) / 2
+		img.DrawText(left, top+idx*CellHeight*scale, line, scale, c)
+	}
+}
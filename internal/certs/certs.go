@@ -0,0 +1,306 @@
+// Package certs is a minimal certificate authority issuing client certificates for phones,
+// signed by an imported CA.
+package certs
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zam-haus/dlsir/internal/atomicfile"
+	"github.com/zam-haus/dlsir/internal/pkcs12"
+)
+
+const (
+	caCertFile = "ca.pem"
+	caKeyFile  = "ca-key.pem"
+	// client certificates are stored as <mac>.pem (certificate) and <mac>.p12 (certificate and key)
+	certExtension   = ".pem"
+	pkcs12Extension = ".p12"
+)
+
+// Store keeps the CA and the issued client certificates in a directory
+type Store struct {
+	Dir string
+
+	mu sync.Mutex
+}
+
+// ClientCert is an issued client certificate
+type ClientCert struct {
+	Mac        string
+	Cert       *x509.Certificate
+	PKCS12File string
+	CertFile   string
+}
+
+func NewStore(dir string) *Store {
+	return &Store{Dir: dir}
+}
+
+// fileName maps a MAC address like 00:11:22:33:44:55 to 001122334455
+func fileName(mac string) string {
+	return strings.ToLower(strings.ReplaceAll(mac, ":", ""))
+}
+
+func readPEM(file string, blockType string) ([]byte, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		var block *pem.Block
+		block, content = pem.Decode(content)
+		if block == nil {
+			return nil, fmt.Errorf("%v contains no %v", file, blockType)
+		}
+		if strings.HasSuffix(block.Type, blockType) {
+			return block.Bytes, nil
+		}
+	}
+}
+
+func readCertificate(file string) (*x509.Certificate, error) {
+	der, err := readPEM(file, "CERTIFICATE")
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+// readPrivateKey reads PKCS#8, PKCS#1 (RSA) and SEC 1 (EC) keys
+func readPrivateKey(file string) (crypto.Signer, error) {
+	der, err := readPEM(file, "PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported key type %T in %v", key, file)
+		}
+		return signer, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported private key format in %v", file)
+}
+
+func writePEM(file string, blockType string, der []byte, perm os.FileMode) error {
+	content := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	return atomicfile.WriteFile(file, content, perm)
+}
+
+// ImportCA validates the CA certificate and its key and copies them into the store
+func (store *Store) ImportCA(certFile string, keyFile string) (*x509.Certificate, error) {
+	cert, err := readCertificate(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificate: %v", err)
+	}
+	key, err := readPrivateKey(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA key: %v", err)
+	}
+
+	if !cert.IsCA {
+		return nil, fmt.Errorf("%v is not a CA certificate", cert.Subject)
+	}
+	if time.Now().After(cert.NotAfter) {
+		return nil, fmt.Errorf("CA certificate expired at %v", cert.NotAfter)
+	}
+
+	type publicKey interface{ Equal(crypto.PublicKey) bool }
+	if pub, ok := key.Public().(publicKey); !ok || !pub.Equal(cert.PublicKey) {
+		return nil, fmt.Errorf("the key doesn't belong to the CA certificate")
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	err = os.MkdirAll(store.Dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	err = writePEM(filepath.Join(store.Dir, caKeyFile), "PRIVATE KEY", keyDER, 0600)
+	if err != nil {
+		return nil, err
+	}
+	err = writePEM(filepath.Join(store.Dir, caCertFile), "CERTIFICATE", cert.Raw, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return cert, nil
+}
+
+// CA returns the CA certificate; the error wraps fs.ErrNotExist if no CA was imported
+func (store *Store) CA() (*x509.Certificate, error) {
+	return readCertificate(filepath.Join(store.Dir, caCertFile))
+}
+
+// CAFile returns the path of the CA certificate (PEM)
+func (store *Store) CAFile() string {
+	return filepath.Join(store.Dir, caCertFile)
+}
+
+// Client returns the client certificate issued for the MAC address (or nil)
+func (store *Store) Client(mac string) (*ClientCert, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	return store.client(mac)
+}
+
+func (store *Store) client(mac string) (*ClientCert, error) {
+	certFile := filepath.Join(store.Dir, fileName(mac)+certExtension)
+	cert, err := readCertificate(certFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &ClientCert{Mac: mac, Cert: cert, CertFile: certFile, PKCS12File: filepath.Join(store.Dir, fileName(mac)+pkcs12Extension)}, nil
+}
+
+// Issue creates a new client certificate for the MAC address, replacing the existing one.
+// The certificate and its key are stored as PKCS#12 protected by password.
+func (store *Store) Issue(mac string, commonName string, validity time.Duration, password string) (*ClientCert, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	caCert, err := readCertificate(filepath.Join(store.Dir, caCertFile))
+	if err != nil {
+		return nil, fmt.Errorf("no CA imported: %v", err)
+	}
+	caKey, err := readPrivateKey(filepath.Join(store.Dir, caKeyFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA key: %v", err)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	if err != nil {
+		return nil, err
+	}
+
+	notAfter := time.Now().Add(validity)
+	if notAfter.After(caCert.NotAfter) {
+		notAfter = caCert.NotAfter
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName, SerialNumber: mac},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, key.Public(), caKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	p12, err := pkcs12.Encode(key, cert, []*x509.Certificate{caCert}, commonName, password)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &ClientCert{Mac: mac, Cert: cert, CertFile: filepath.Join(store.Dir, fileName(mac)+certExtension), PKCS12File: filepath.Join(store.Dir, fileName(mac)+pkcs12Extension)}
+	// the certificate is written last, as its presence marks a client as issued
+	err = atomicfile.WriteFile(client.PKCS12File, p12, 0600)
+	if err != nil {
+		return nil, err
+	}
+	err = writePEM(client.CertFile, "CERTIFICATE", der, 0644)
+	if err != nil {
+		// don't leave a key that doesn't match the certificate
+		os.Remove(client.PKCS12File)
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// List returns all issued client certificates ordered by expiry
+func (store *Store) List() ([]ClientCert, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	dirEntries, err := os.ReadDir(store.Dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []ClientCert{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]ClientCert, 0)
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		if filepath.Ext(name) != certExtension || name == caCertFile || name == caKeyFile {
+			continue
+		}
+
+		certFile := filepath.Join(store.Dir, name)
+		cert, err := readCertificate(certFile)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, ClientCert{Mac: cert.Subject.SerialNumber, Cert: cert, CertFile: certFile, PKCS12File: strings.TrimSuffix(certFile, certExtension) + pkcs12Extension})
+	}
+
+	slices.SortFunc(res, func(a, b ClientCert) int { return a.Cert.NotAfter.Compare(b.Cert.NotAfter) })
+	return res, nil
+}
+
+// ExpiresWithCA returns whether the certificate is valid as long as the CA, i.e. its validity
+// was capped by the CA and can't be extended by renewing it
+func (client *ClientCert) ExpiresWithCA(ca *x509.Certificate) bool {
+	return !client.Cert.NotAfter.Before(ca.NotAfter)
+}
+
+// NeedsRenewal returns whether the certificate was not issued by the current CA or expires within
+// renewBefore. Certificates expiring with the CA are not renewed, as a new one wouldn't last longer.
+func (client *ClientCert) NeedsRenewal(ca *x509.Certificate, renewBefore time.Duration, now time.Time) bool {
+	if client.Cert.CheckSignatureFrom(ca) != nil || !bytes.Equal(client.Cert.RawIssuer, ca.RawSubject) {
+		return true
+	}
+	return now.Add(renewBefore).After(client.Cert.NotAfter) && !client.ExpiresWithCA(ca)
+}
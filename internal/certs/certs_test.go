@@ -0,0 +1,243 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testMac = "00:1A:2B:3C:4D:5E"
+
+// newCA creates a throwaway CA valid for the given duration and returns the paths of its
+// certificate and key
+func newCA(t *testing.T, name string, validity time.Duration) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, name+".pem")
+	keyFile := filepath.Join(dir, name+"-key.pem")
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// newStore returns a store with an imported CA valid for the given duration
+func newStore(t *testing.T, validity time.Duration) (*Store, *x509.Certificate) {
+	t.Helper()
+
+	store := NewStore(filepath.Join(t.TempDir(), "certs"))
+	ca, err := store.ImportCA(newCA(t, "ca", validity))
+	if err != nil {
+		t.Fatalf("ImportCA: %v", err)
+	}
+	return store, ca
+}
+
+func TestImportCA(t *testing.T) {
+	store, ca := newStore(t, 365*24*time.Hour)
+
+	stored, err := store.CA()
+	if err != nil {
+		t.Fatalf("CA: %v", err)
+	}
+	if !stored.Equal(ca) {
+		t.Errorf("CA returned another certificate")
+	}
+	if info, err := os.Stat(filepath.Join(store.Dir, caKeyFile)); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("CA key: %v, %v, want mode 0600", info, err)
+	}
+
+	certFile, _ := newCA(t, "ca", time.Hour)
+	_, otherKey := newCA(t, "other", time.Hour)
+	expiredCert, expiredKey := newCA(t, "expired", -time.Minute)
+
+	tests := []struct {
+		name     string
+		certFile string
+		keyFile  string
+		err      string
+	}{
+		{"key of another CA", certFile, otherKey, "doesn't belong"},
+		{"expired", expiredCert, expiredKey, "expired"},
+		{"missing certificate", filepath.Join(t.TempDir(), "missing.pem"), otherKey, "failed to read CA certificate"},
+		{"key is no key", certFile, certFile, "failed to read CA key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewStore(t.TempDir()).ImportCA(tt.certFile, tt.keyFile)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("ImportCA error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestIssue(t *testing.T) {
+	store, ca := newStore(t, 365*24*time.Hour)
+
+	if _, err := NewStore(t.TempDir()).Issue(testMac, "phone", time.Hour, "secret"); err == nil {
+		t.Errorf("Issue without a CA succeeded")
+	}
+
+	client, err := store.Issue(testMac, "phone 4242", 30*24*time.Hour, "secret")
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+
+	if err := client.Cert.CheckSignatureFrom(ca); err != nil {
+		t.Errorf("certificate not signed by the CA: %v", err)
+	}
+	if client.Cert.Subject.CommonName != "phone 4242" || client.Cert.Subject.SerialNumber != testMac {
+		t.Errorf("Subject = %v", client.Cert.Subject)
+	}
+	if want := time.Now().Add(30 * 24 * time.Hour); client.Cert.NotAfter.Sub(want).Abs() > time.Minute {
+		t.Errorf("NotAfter = %v, want %v", client.Cert.NotAfter, want)
+	}
+	if filepath.Base(client.CertFile) != "001a2b3c4d5e.pem" || filepath.Base(client.PKCS12File) != "001a2b3c4d5e.p12" {
+		t.Errorf("files = %v, %v", client.CertFile, client.PKCS12File)
+	}
+	if info, err := os.Stat(client.PKCS12File); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("PKCS#12 file: %v, %v, want mode 0600", info, err)
+	}
+	if client.ExpiresWithCA(ca) {
+		t.Errorf("ExpiresWithCA of a certificate expiring before the CA")
+	}
+
+	stored, err := store.Client(testMac)
+	if err != nil || stored == nil || !stored.Cert.Equal(client.Cert) {
+		t.Errorf("Client = %v, %v, want the issued certificate", stored, err)
+	}
+	if stored, err := store.Client("00:00:00:00:00:00"); stored != nil || err != nil {
+		t.Errorf("Client of unknown phone = %v, %v, want nil", stored, err)
+	}
+
+	// a certificate lasting longer than the CA is capped at its expiry
+	capped, err := store.Issue("00:1a:2b:3c:4d:5f", "phone 4343", 5*365*24*time.Hour, "secret")
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	if !capped.Cert.NotAfter.Equal(ca.NotAfter) || !capped.ExpiresWithCA(ca) {
+		t.Errorf("NotAfter = %v, want the CA's expiry %v", capped.Cert.NotAfter, ca.NotAfter)
+	}
+
+	list, err := store.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(list) != 2 || list[0].Mac != testMac || list[1].Mac != "00:1a:2b:3c:4d:5f" {
+		t.Errorf("List = %v, want both certificates ordered by expiry", list)
+	}
+
+	// no temporary files are left behind
+	files, _ := filepath.Glob(filepath.Join(store.Dir, "*.tmp"))
+	if len(files) != 0 {
+		t.Errorf("temporary files left: %v", files)
+	}
+}
+
+func TestIssueFailedCertificate(t *testing.T) {
+	store, _ := newStore(t, 365*24*time.Hour)
+
+	// the certificate can't replace a directory
+	err := os.Mkdir(filepath.Join(store.Dir, fileName(testMac)+certExtension), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Issue(testMac, "phone", time.Hour, "secret"); err == nil {
+		t.Fatalf("Issue succeeded")
+	}
+	if _, err := os.Stat(filepath.Join(store.Dir, fileName(testMac)+pkcs12Extension)); !os.IsNotExist(err) {
+		t.Errorf("PKCS#12 file of the failed certificate was kept: %v", err)
+	}
+}
+
+func TestNeedsRenewal(t *testing.T) {
+	day := 24 * time.Hour
+	store, ca := newStore(t, 365*day)
+
+	client, err := store.Issue(testMac, "phone", 30*day, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	capped, err := store.Issue("00:1a:2b:3c:4d:5f", "phone", 400*day, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherStore, otherCA := newStore(t, 365*day)
+	// a CA with the same name, but another key
+	sameName := NewStore(filepath.Join(t.TempDir(), "certs"))
+	sameNameCA, err := sameName.ImportCA(newCA(t, "ca", 365*day))
+	if err != nil {
+		t.Fatal(err)
+	}
+	foreign, err := otherStore.Issue(testMac, "phone", 30*day, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	tests := []struct {
+		name        string
+		client      *ClientCert
+		ca          *x509.Certificate
+		renewBefore time.Duration
+		now         time.Time
+		want        bool
+	}{
+		{"valid", client, ca, 7 * day, now, false},
+		{"inside renewal window", client, ca, 7 * day, now.Add(24 * day), true},
+		{"renewal window covers validity", client, ca, 31 * day, now, true},
+		{"expired", client, ca, 0, now.Add(31 * day), true},
+		{"capped at CA expiry", capped, ca, 7 * day, now.Add(360 * day), false},
+		{"issued by another CA", foreign, ca, 7 * day, now, true},
+		{"issued by the current CA", foreign, otherCA, 7 * day, now, false},
+		{"CA with the same name", client, sameNameCA, 7 * day, now, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.client.NeedsRenewal(tt.ca, tt.renewBefore, tt.now); got != tt.want {
+				t.Errorf("NeedsRenewal = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
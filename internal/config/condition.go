@@ -0,0 +1,130 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/zam-haus/dlsir/internal/firmware"
+)
+
+// Device describes the phone a configuration is selected for
+type Device struct {
+	Type      string
+	FwVersion firmware.FirmwareVersion
+}
+
+type conditionClause struct {
+	Field string
+	Op    string
+	// alternatives for device-type (separated by '|')
+	Values  []string
+	Version firmware.FirmwareVersion
+}
+
+// Condition guards the entries of a config section, e.g.
+//
+//	[device-type = OpenStage 60 | OpenStage 80, firmware >= V3 R3.0.0]
+//	...
+//	[end]
+//
+// All clauses must match.
+type Condition struct {
+	Text    string
+	clauses []conditionClause
+}
+
+var clauseRx = regexp.MustCompile(`^\s*(device-type|firmware)\s*(<=|>=|!=|=|<|>)\s*(.+?)\s*$`)
+
+// the whole value of a firmware clause must be a version, e.g. "V3 R3.0.0"
+var conditionVersionRx = regexp.MustCompile(`^V\d+(\.\d+)? R\d+\.\d+\.\d+$`)
+
+func parseCondition(text string) (*Condition, error) {
+	cond := Condition{Text: text, clauses: make([]conditionClause, 0)}
+
+	for _, part := range strings.Split(text, ",") {
+		m := clauseRx.FindStringSubmatch(part)
+		if m == nil {
+			return nil, fmt.Errorf("invalid condition '%v'", strings.TrimSpace(part))
+		}
+
+		clause := conditionClause{Field: m[1], Op: m[2]}
+
+		if clause.Field == "device-type" {
+			if clause.Op != "=" && clause.Op != "!=" {
+				return nil, fmt.Errorf("device-type only supports = and != in condition '%v'", strings.TrimSpace(part))
+			}
+
+			for _, value := range strings.Split(m[3], "|") {
+				clause.Values = append(clause.Values, GetDeviceKey(strings.TrimSpace(value)))
+			}
+		} else {
+			if !conditionVersionRx.MatchString(m[3]) {
+				return nil, fmt.Errorf("invalid firmware version '%v' in condition '%v', expected Vx[.y] Rm.f.h", m[3], strings.TrimSpace(part))
+			}
+
+			ver, err := firmware.ParseFirmwareVersion(m[3])
+			if err != nil {
+				return nil, fmt.Errorf("invalid firmware version in condition '%v': %v", strings.TrimSpace(part), err)
+			}
+			clause.Version = *ver
+		}
+
+		cond.clauses = append(cond.clauses, clause)
+	}
+
+	return &cond, nil
+}
+
+func (clause conditionClause) matches(device Device) bool {
+	if clause.Field == "device-type" {
+		found := false
+		for _, value := range clause.Values {
+			if value == GetDeviceKey(device.Type) {
+				found = true
+			}
+		}
+		return found == (clause.Op == "=")
+	}
+
+	cmp := device.FwVersion.Compare(clause.Version)
+	switch clause.Op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case ">=":
+		return cmp >= 0
+	case ">":
+		return cmp > 0
+	}
+
+	return false
+}
+
+// Matches returns whether all clauses of the condition match the device
+func (cond *Condition) Matches(device Device) bool {
+	for _, clause := range cond.clauses {
+		if !clause.matches(device) {
+			return false
+		}
+	}
+	return true
+}
+
+// Select returns the entries applying to the given device.
+// Entries of a matching section replace earlier entries with the same name and index.
+func (conf ConfigFile) Select(device Device) ConfigFile {
+	selected := make([]ConfigEntry, 0)
+	for _, entry := range conf.Entries {
+		if entry.Condition == nil || entry.Condition.Matches(device) {
+			selected = append(selected, entry)
+		}
+	}
+
+	return ConfigFile{Name: conf.Name, Entries: mergeEntryLists([]ConfigEntry{}, selected)}
+}
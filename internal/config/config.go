@@ -7,12 +7,21 @@ import (
 	"regexp"
 	"slices"
 	"strings"
+	"time"
 )
 
+// Entries starting with LocalPrefix are only evaluated by DLSir and never sent to a phone
+const LocalPrefix = "dlsir-"
+
+// Comma-separated list of groups a phone belongs to
+const GroupsEntry = LocalPrefix + "groups"
+
 type ConfigEntry struct {
 	Name  string
 	Index string
 	Value string
+	// set for entries in a conditional section; see Select
+	Condition *Condition
 }
 
 type ConfigFile struct {
@@ -35,16 +44,78 @@ func (conf ConfigFile) GetEntry(name string) (ConfigEntry, error) {
 	return getEntry(conf.Entries, name)
 }
 
+// Ends a conditional section
+const SectionEnd = "end"
+
+var entryRx = regexp.MustCompile(`\s*(?P<Key>[^\[\]= \t]+)(\[(?P<Index>\d+)\])?\s*=\s*(?P<Value>.*)\s*`)
+var keyRx = regexp.MustCompile(`^(?P<Key>[^\[\]= \t]+)(\[(?P<Index>\d+)\])?$`)
+
+// Key returns the entry's name including its index, i.e. the part left of '='
+func (entry ConfigEntry) Key() string {
+	if entry.Index != "" {
+		return entry.Name + "[" + entry.Index + "]"
+	}
+	return entry.Name
+}
+
+func (entry ConfigEntry) String() string {
+	return entry.Key() + " = " + entry.Value
+}
+
+// ParseKey splits a key of the form name[index] into name and (optional) index
+func ParseKey(key string) (string, string, error) {
+	m := keyRx.FindStringSubmatch(strings.TrimSpace(key))
+	if m == nil {
+		return "", "", fmt.Errorf("'%v' is not a valid config key", key)
+	}
+	return m[1], m[3], nil
+}
+
+// SetEntries replaces all entries with the same name and index or appends them
+func (conf *ConfigFile) SetEntries(entries []ConfigEntry) {
+	conf.Entries = mergeEntryLists(conf.Entries, entries)
+}
+
+// GetGroups returns the groups listed in the groups entry
+func (conf ConfigFile) GetGroups() []string {
+	entry, err := conf.GetEntry(GroupsEntry)
+	if err != nil {
+		return []string{}
+	}
+
+	return SplitList(entry.Value)
+}
+
+// SplitList splits a comma-separated value into its trimmed, non-empty parts
+func SplitList(value string) []string {
+	res := make([]string, 0)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part != "" {
+			res = append(res, part)
+		}
+	}
+	return res
+}
+
+// sectionHeader checks whether line is a section header like [condition] and returns its content
+func sectionHeader(line string) (bool, string) {
+	if len(line) < 2 || line[0] != '[' || line[len(line)-1] != ']' {
+		return false, ""
+	}
+	return true, strings.TrimSpace(line[1 : len(line)-1])
+}
+
 func entriesFromFile(confFile string) ([]ConfigEntry, error) {
 	conf, err := os.ReadFile(confFile)
 	if err != nil {
 		return nil, fmt.Errorf("unable to read file %v: %v", confFile, err)
 	}
 
-	rx := regexp.MustCompile(`\s*(?P<Key>[^\[\]= \t]+)(\[(?P<Index>\d+)\])?\s*=\s*(?P<Value>.*)\s*`)
-
 	entries := make([]ConfigEntry, 0)
 
+	var condition *Condition
+
 	lines := strings.Split(string(conf), "\n")
 	for lineNo, line := range lines {
 		line = strings.Trim(line, " \t")
@@ -52,14 +123,25 @@ func entriesFromFile(confFile string) ([]ConfigEntry, error) {
 			continue
 		}
 
-		m := rx.FindAllStringSubmatch(line, -1)
+		if isSection, text := sectionHeader(line); isSection {
+			condition = nil
+			if text != SectionEnd {
+				condition, err = parseCondition(text)
+				if err != nil {
+					return nil, fmt.Errorf("line #%v: %v", lineNo, err)
+				}
+			}
+			continue
+		}
+
+		m := entryRx.FindAllStringSubmatch(line, -1)
 		if m == nil {
 			return nil, fmt.Errorf("line #%v '%v' has invalid format", lineNo, line)
 		}
 
 		key, index, value := m[0][1], m[0][3], m[0][4]
 
-		entries = append(entries, ConfigEntry{Name: key, Index: index, Value: value})
+		entries = append(entries, ConfigEntry{Name: key, Index: index, Value: value, Condition: condition})
 	}
 
 	return entries, nil
@@ -99,21 +181,49 @@ func GetConfigFile(confFile string) (*ConfigFile, error) {
 	return &ConfigFile{Name: confFile, Entries: entries}, nil
 }
 
-func GetMergedConfig(specificFile string, defaultFile string) (*ConfigFile, error) {
-	phoneEntries, err := entriesFromFile(specificFile)
+// GetMergedConfig merges the entries of both files applying to device; entries of specificFile take precedence
+func GetMergedConfig(specificFile string, defaultFile string, device Device) (*ConfigFile, error) {
+	phoneConf, err := GetConfigFile(specificFile)
 	if err != nil {
 		return nil, err
 	}
 
-	defaultEntries, err := entriesFromFile(defaultFile)
+	defaultConf, err := GetConfigFile(defaultFile)
 	if err != nil {
 		return nil, err
 	}
 
-	entries := mergeEntryLists(defaultEntries, phoneEntries)
+	entries := mergeEntryLists(defaultConf.Select(device).Entries, phoneConf.Select(device).Entries)
 	return &ConfigFile{Name: "MergedConfig("+specificFile+", "+defaultFile+")", Entries: entries}, nil
 }
 
+// GetExpandedConfig is like GetMergedConfig, but expands the key definitions and the time zone of
+// each file before merging them, so that raw items of specificFile win over key definitions or the
+// time zone of defaultFile
+func GetExpandedConfig(specificFile string, defaultFile string, device Device) (*ConfigFile, error) {
+	files := make([]ConfigFile, 0, 2)
+	for _, file := range []string{defaultFile, specificFile} {
+		conf, err := GetConfigFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		selected := conf.Select(device)
+		err = selected.ExpandKeys(device.Type)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", file, err)
+		}
+		err = selected.ExpandTimezone(time.Now())
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", file, err)
+		}
+		files = append(files, selected)
+	}
+
+	entries := mergeEntryLists(files[0].Entries, files[1].Entries)
+	return &ConfigFile{Name: "ExpandedConfig(" + specificFile + ", " + defaultFile + ")", Entries: entries}, nil
+}
+
 func GetFwItemName(devType string) string {
-	return "fw-" + strings.ReplaceAll(strings.ToLower(devType), " ", "")
+	return "fw-" + GetDeviceKey(devType)
 }
@@ -0,0 +1,162 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/zam-haus/dlsir/internal/firmware"
+)
+
+// Entry name of high-level function key definitions, e.g.
+//
+//	key[3] = speed-dial 4711 label "Reception"
+//	key[1001] = blf 4242
+//	key[4] = clear
+const KeyEntry = "key"
+
+type keyFunction struct {
+	// value of function-key-def
+	Code string
+	// item holding the number for this function (if any)
+	TargetItem string
+}
+
+var keyFunctions = map[string]keyFunction{
+	"clear":      {Code: "0"},
+	"speed-dial": {Code: "1", TargetItem: "select-dial"},
+	"blf":        {Code: "59", TargetItem: "blf-code"},
+}
+
+type keyLayout struct {
+	// number of programmable keys on the phone itself (index 1..Keys)
+	Keys int
+	// number of supported key modules; module n uses indices n*1000+1..n*1000+ModuleKeys
+	Modules    int
+	ModuleKeys int
+}
+
+// keyed by GetDeviceKey(device-type)
+var keyLayouts = map[string]keyLayout{
+	"openstage15": {Keys: 8, Modules: 2, ModuleKeys: 18},
+	"openstage40": {Keys: 6, Modules: 2, ModuleKeys: 12},
+	"openstage60": {Keys: 8, Modules: 2, ModuleKeys: 12},
+	"openstage80": {Keys: 9, Modules: 2, ModuleKeys: 12},
+}
+
+// GetDeviceKey normalizes a device type like "OpenStage 40" to "openstage40"
+func GetDeviceKey(devType string) string {
+	return firmware.DeviceKey(devType)
+}
+
+func (layout keyLayout) isValid(index int) bool {
+	if index >= 1 && index <= layout.Keys {
+		return true
+	}
+
+	module, key := index/1000, index%1000
+	return module >= 1 && module <= layout.Modules && key >= 1 && key <= layout.ModuleKeys
+}
+
+// splitKeyDefinition splits a definition into words; quoted strings are kept together
+func splitKeyDefinition(def string) ([]string, error) {
+	words := make([]string, 0)
+
+	for def = strings.TrimSpace(def); def != ""; def = strings.TrimSpace(def) {
+		if def[0] == '"' || def[0] == '\'' {
+			end := strings.IndexByte(def[1:], def[0])
+			if end == -1 {
+				return nil, fmt.Errorf("unterminated quote in '%v'", def)
+			}
+			words = append(words, def[1:end+1])
+			def = def[end+2:]
+			continue
+		}
+
+		word, rest, _ := strings.Cut(def, " ")
+		words = append(words, word)
+		def = rest
+	}
+
+	return words, nil
+}
+
+func expandKey(entry ConfigEntry, layout *keyLayout) ([]ConfigEntry, error) {
+	index, err := strconv.Atoi(entry.Index)
+	if err != nil {
+		return nil, fmt.Errorf("key definition '%v' requires an index", entry)
+	}
+
+	if layout != nil && !layout.isValid(index) {
+		return nil, fmt.Errorf("key index %v is not available on this device", index)
+	}
+
+	words, err := splitKeyDefinition(entry.Value)
+	if err != nil {
+		return nil, err
+	}
+	if len(words) == 0 {
+		return nil, fmt.Errorf("empty key definition for %v", entry.Key())
+	}
+
+	name := words[0]
+	function, ok := keyFunctions[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown key function '%v' in %v", name, entry)
+	}
+	words = words[1:]
+
+	target := ""
+	if function.TargetItem != "" {
+		if len(words) == 0 || words[0] == "label" {
+			return nil, fmt.Errorf("key function '%v' requires a number in %v", name, entry)
+		}
+		target, words = words[0], words[1:]
+	}
+
+	label := ""
+	if len(words) == 2 && words[0] == "label" {
+		label = words[1]
+	} else if len(words) != 0 {
+		return nil, fmt.Errorf("unexpected '%v' in %v", strings.Join(words, " "), entry)
+	}
+
+	res := []ConfigEntry{
+		{Name: "key-label-unicode", Index: entry.Index, Value: label},
+		{Name: "function-key-def", Index: entry.Index, Value: function.Code},
+	}
+	if function.TargetItem != "" {
+		res = append(res, ConfigEntry{Name: function.TargetItem, Index: entry.Index, Value: target})
+	}
+
+	return res, nil
+}
+
+// ExpandKeys replaces all key definitions by the raw function key items for the given device type.
+// Key indices are only checked for known device types.
+func (conf *ConfigFile) ExpandKeys(devType string) error {
+	var layout *keyLayout
+	if l, ok := keyLayouts[GetDeviceKey(devType)]; ok {
+		layout = &l
+	}
+
+	expanded := make([]ConfigEntry, 0)
+	others := make([]ConfigEntry, 0)
+	for _, entry := range conf.Entries {
+		if entry.Name != KeyEntry {
+			others = append(others, entry)
+			continue
+		}
+
+		items, err := expandKey(entry, layout)
+		if err != nil {
+			return err
+		}
+		expanded = append(expanded, items...)
+	}
+
+	conf.Entries = others
+	conf.SetEntries(expanded)
+
+	return nil
+}
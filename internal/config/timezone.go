@@ -0,0 +1,161 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	// don't depend on the zoneinfo database of the host
+	_ "time/tzdata"
+)
+
+// Entry name of the IANA time zone, e.g. timezone = Europe/Berlin
+const TimezoneEntry = "timezone"
+
+// DST zones (daylight-save-zone-id) as listed in the OpenStage administration manual
+const (
+	dstZoneAustralia    = 2
+	dstZoneCanada       = 5
+	dstZoneNewfoundland = 6
+	dstZoneEurope       = 9
+	dstZoneNewZealand   = 11
+	dstZoneChatham      = 12
+	dstZoneParaguay     = 13
+	dstZoneUSA          = 15
+)
+
+// DST zones of IANA zones whose DST rules can't be told apart by their transition dates
+var dstZoneNames = map[string]int{
+	"America/St_Johns":  dstZoneNewfoundland,
+	"America/Toronto":   dstZoneCanada,
+	"America/Halifax":   dstZoneCanada,
+	"America/Moncton":   dstZoneCanada,
+	"America/Winnipeg":  dstZoneCanada,
+	"America/Edmonton":  dstZoneCanada,
+	"America/Vancouver": dstZoneCanada,
+	"Pacific/Chatham":   dstZoneChatham,
+}
+
+// All other zones are mapped to the first DST zone with the same transition dates as the reference zone
+var dstZoneReferences = []struct {
+	Zone      int
+	Reference string
+}{
+	{dstZoneEurope, "Europe/Berlin"},
+	{dstZoneUSA, "America/New_York"},
+	{dstZoneAustralia, "Australia/Sydney"},
+	{dstZoneNewZealand, "Pacific/Auckland"},
+	{dstZoneParaguay, "America/Asuncion"},
+}
+
+// Timezone holds the OpenStage representation of a time zone
+type Timezone struct {
+	// standard (non-DST) offset to UTC in minutes
+	Offset int
+	// whether the zone observes daylight saving time at all
+	HasDST bool
+	// whether daylight saving time is currently in effect
+	IsDST bool
+	// value for daylight-save-zone-id; only valid if HasDST is set
+	DSTZone int
+}
+
+// dstTransitions returns the days of the year on which the UTC offset of loc changes
+func dstTransitions(loc *time.Location, year int) []int {
+	res := make([]int, 0)
+
+	day := time.Date(year, time.January, 1, 12, 0, 0, 0, loc)
+	_, lastOffset := day.Zone()
+	for ; day.Year() == year; day = day.AddDate(0, 0, 1) {
+		_, offset := day.Zone()
+		if offset != lastOffset {
+			res = append(res, day.YearDay())
+			lastOffset = offset
+		}
+	}
+
+	return res
+}
+
+func findDSTZone(name string, loc *time.Location, year int) (int, bool) {
+	if zone, ok := dstZoneNames[name]; ok {
+		return zone, true
+	}
+
+	transitions := dstTransitions(loc, year)
+	for _, ref := range dstZoneReferences {
+		refLoc, err := time.LoadLocation(ref.Reference)
+		if err != nil {
+			continue
+		}
+
+		if slices.Equal(transitions, dstTransitions(refLoc, year)) {
+			return ref.Zone, true
+		}
+	}
+
+	return 0, false
+}
+
+// GetTimezone converts an IANA zone name into the phone's time zone settings at the given time
+func GetTimezone(name string, now time.Time) (*Timezone, error) {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone '%v': %v", name, err)
+	}
+
+	// the standard offset is the smaller one of winter and summer (on both hemispheres)
+	_, janOffset := time.Date(now.Year(), time.January, 1, 12, 0, 0, 0, loc).Zone()
+	_, julOffset := time.Date(now.Year(), time.July, 1, 12, 0, 0, 0, loc).Zone()
+	_, curOffset := now.In(loc).Zone()
+
+	tz := Timezone{Offset: min(janOffset, julOffset) / 60, HasDST: janOffset != julOffset}
+	if !tz.HasDST {
+		return &tz, nil
+	}
+
+	tz.IsDST = curOffset != tz.Offset*60
+
+	zone, ok := findDSTZone(name, loc, now.Year())
+	if !ok {
+		return nil, fmt.Errorf("no OpenStage DST zone known for time zone '%v'", name)
+	}
+	tz.DSTZone = zone
+
+	return &tz, nil
+}
+
+// ExpandTimezone replaces the timezone entry by the phone's time zone and DST items
+func (conf *ConfigFile) ExpandTimezone(now time.Time) error {
+	entry, err := conf.GetEntry(TimezoneEntry)
+	if err != nil {
+		return nil
+	}
+
+	tz, err := GetTimezone(entry.Value, now)
+	if err != nil {
+		return err
+	}
+
+	items := []ConfigEntry{
+		{Name: "sntp-tz-offset", Value: strconv.Itoa(tz.Offset)},
+		{Name: "daylight-save", Value: strconv.FormatBool(tz.IsDST)},
+		{Name: "auto-daylight-save", Value: strconv.FormatBool(tz.HasDST)},
+	}
+	if tz.HasDST {
+		items = append(items, ConfigEntry{Name: "daylight-save-zone-id", Value: strconv.Itoa(tz.DSTZone)})
+	}
+
+	others := make([]ConfigEntry, 0)
+	for _, entry := range conf.Entries {
+		if entry.Name != TimezoneEntry {
+			others = append(others, entry)
+		}
+	}
+
+	conf.Entries = others
+	conf.SetEntries(items)
+
+	return nil
+}
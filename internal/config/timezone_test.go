@@ -0,0 +1,177 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGetTimezone(t *testing.T) {
+	winter := time.Date(2024, time.January, 15, 12, 0, 0, 0, time.UTC)
+	summer := time.Date(2024, time.July, 15, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		now  time.Time
+		want Timezone
+	}{
+		{"UTC", winter, Timezone{Offset: 0}},
+		{"Asia/Tokyo", summer, Timezone{Offset: 540}},
+		{"Asia/Kolkata", winter, Timezone{Offset: 330}},
+		{"America/Phoenix", summer, Timezone{Offset: -420}},
+		{"Europe/Berlin", winter, Timezone{Offset: 60, HasDST: true, DSTZone: dstZoneEurope}},
+		{"Europe/Berlin", summer, Timezone{Offset: 60, HasDST: true, IsDST: true, DSTZone: dstZoneEurope}},
+		{"Europe/London", summer, Timezone{Offset: 0, HasDST: true, IsDST: true, DSTZone: dstZoneEurope}},
+		{"Europe/Helsinki", winter, Timezone{Offset: 120, HasDST: true, DSTZone: dstZoneEurope}},
+		{"America/New_York", summer, Timezone{Offset: -300, HasDST: true, IsDST: true, DSTZone: dstZoneUSA}},
+		{"America/Los_Angeles", winter, Timezone{Offset: -480, HasDST: true, DSTZone: dstZoneUSA}},
+		{"America/Toronto", winter, Timezone{Offset: -300, HasDST: true, DSTZone: dstZoneCanada}},
+		{"America/St_Johns", summer, Timezone{Offset: -210, HasDST: true, IsDST: true, DSTZone: dstZoneNewfoundland}},
+		{"Australia/Sydney", winter, Timezone{Offset: 600, HasDST: true, IsDST: true, DSTZone: dstZoneAustralia}},
+		{"Australia/Melbourne", summer, Timezone{Offset: 600, HasDST: true, DSTZone: dstZoneAustralia}},
+		{"Pacific/Auckland", winter, Timezone{Offset: 720, HasDST: true, IsDST: true, DSTZone: dstZoneNewZealand}},
+		{"Pacific/Chatham", summer, Timezone{Offset: 765, HasDST: true, DSTZone: dstZoneChatham}},
+		{"America/Asuncion", summer, Timezone{Offset: -240, HasDST: true, DSTZone: dstZoneParaguay}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name+"@"+tt.now.Month().String(), func(t *testing.T) {
+			tz, err := GetTimezone(tt.name, tt.now)
+			if err != nil {
+				t.Fatalf("GetTimezone(%v): %v", tt.name, err)
+			}
+			if *tz != tt.want {
+				t.Errorf("GetTimezone(%v) = %+v, want %+v", tt.name, *tz, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetTimezoneUnmapped(t *testing.T) {
+	now := time.Date(2024, time.January, 15, 12, 0, 0, 0, time.UTC)
+
+	tests := []string{
+		// DST rules unknown to the phone
+		"America/Santiago",
+		"Asia/Jerusalem",
+		// not a zone at all
+		"Europe/Atlantis",
+	}
+
+	for _, name := range tests {
+		t.Run(name, func(t *testing.T) {
+			tz, err := GetTimezone(name, now)
+			if err == nil {
+				t.Errorf("GetTimezone(%q) = %+v, want error", name, *tz)
+			}
+		})
+	}
+}
+
+func TestExpandTimezone(t *testing.T) {
+	now := time.Date(2024, time.July, 15, 12, 0, 0, 0, time.UTC)
+
+	conf := ConfigFile{Entries: []ConfigEntry{
+		{Name: "e164", Value: "4242"},
+		{Name: TimezoneEntry, Value: "Europe/Berlin"},
+		{Name: "sntp-tz-offset", Value: "0"},
+	}}
+	err := conf.ExpandTimezone(now)
+	if err != nil {
+		t.Fatalf("ExpandTimezone: %v", err)
+	}
+
+	want := map[string]string{
+		"e164":                  "4242",
+		"sntp-tz-offset":        "60",
+		"daylight-save":         "true",
+		"auto-daylight-save":    "true",
+		"daylight-save-zone-id": "9",
+	}
+	if len(conf.Entries) != len(want) {
+		t.Errorf("ExpandTimezone returned %v, want %v entries", conf.Entries, len(want))
+	}
+	for name, value := range want {
+		entry, err := conf.GetEntry(name)
+		if err != nil || entry.Value != value {
+			t.Errorf("%v = %q, want %q", name, entry.Value, value)
+		}
+	}
+
+	conf = ConfigFile{Entries: []ConfigEntry{{Name: TimezoneEntry, Value: "Asia/Tokyo"}}}
+	err = conf.ExpandTimezone(now)
+	if err != nil {
+		t.Fatalf("ExpandTimezone: %v", err)
+	}
+	if _, err := conf.GetEntry("daylight-save-zone-id"); err == nil {
+		t.Errorf("daylight-save-zone-id set for a zone without DST")
+	}
+
+	conf = ConfigFile{Entries: []ConfigEntry{{Name: TimezoneEntry, Value: "America/Santiago"}}}
+	if err := conf.ExpandTimezone(now); err == nil {
+		t.Errorf("ExpandTimezone accepted an unmapped zone")
+	}
+}
+
+func TestGetExpandedConfigTimezone(t *testing.T) {
+	dir := t.TempDir()
+	defaultFile := filepath.Join(dir, "phonedefault.conf")
+	phoneFile := filepath.Join(dir, "00:1a:2b:3c:4d:5e.conf")
+
+	write := func(file string, content string) {
+		t.Helper()
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		phone string
+		want  map[string]string
+	}{
+		{
+			name:  "phone overrides the offset",
+			phone: "sntp-tz-offset = 120\n",
+			want:  map[string]string{"sntp-tz-offset": "120", "auto-daylight-save": "true", "daylight-save-zone-id": "9"},
+		},
+		{
+			name:  "phone disables DST",
+			phone: "auto-daylight-save = false\ndaylight-save = false\n",
+			want:  map[string]string{"sntp-tz-offset": "60", "auto-daylight-save": "false", "daylight-save": "false"},
+		},
+		{
+			name:  "phone has its own time zone",
+			phone: "timezone = Asia/Tokyo\n",
+			want:  map[string]string{"sntp-tz-offset": "540", "auto-daylight-save": "false", "daylight-save": "false"},
+		},
+		{
+			name:  "default only",
+			phone: "e164 = 4242\n",
+			want:  map[string]string{"sntp-tz-offset": "60", "auto-daylight-save": "true", "daylight-save-zone-id": "9"},
+		},
+	}
+
+	write(defaultFile, "timezone = Europe/Berlin\n")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			write(phoneFile, tt.phone)
+
+			conf, err := GetExpandedConfig(phoneFile, defaultFile, Device{Type: "OpenStage 40"})
+			if err != nil {
+				t.Fatalf("GetExpandedConfig: %v", err)
+			}
+
+			if _, err := conf.GetEntry(TimezoneEntry); err == nil {
+				t.Errorf("timezone entry wasn't expanded")
+			}
+			for name, value := range tt.want {
+				entry, err := conf.GetEntry(name)
+				if err != nil || entry.Value != value {
+					t.Errorf("%v = %q, want %q", name, entry.Value, value)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+)
+
+// EntryChange describes a single modification of a config file
+type EntryChange struct {
+	Entry    ConfigEntry
+	OldValue string
+	Added    bool
+}
+
+func (change EntryChange) String() string {
+	if change.Added {
+		return "+" + change.Entry.String()
+	}
+
+	old := change.Entry
+	old.Value = change.OldValue
+	return "-" + old.String() + "\n+" + change.Entry.String()
+}
+
+// UpdateConfigFile sets the given entries in confFile.
+// Existing lines (including comments) are kept in place, new entries are placed behind
+// the last entry of the same name or appended to the end of the file.
+// Entries in conditional sections are left untouched.
+// A missing file is created. If dryRun is set, the file is not written at all.
+// All changes made (or that would have been made) are returned.
+func UpdateConfigFile(confFile string, entries []ConfigEntry, dryRun bool) ([]EntryChange, error) {
+	content, err := os.ReadFile(confFile)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("unable to read file %v: %v", confFile, err)
+	}
+
+	lines := strings.Split(string(content), "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	changes := make([]EntryChange, 0)
+
+	for _, entry := range entries {
+		found := false
+		lastOfName := -1
+		inSection := false
+
+		for lineNo, line := range lines {
+			line = strings.Trim(line, " \t")
+			if len(line) == 0 || line[0] == '#' {
+				continue
+			}
+
+			// entries of conditional sections are never touched
+			if isSection, text := sectionHeader(line); isSection {
+				inSection = text != SectionEnd
+				continue
+			}
+			if inSection {
+				continue
+			}
+
+			m := entryRx.FindStringSubmatch(line)
+			if m != nil && m[1] == entry.Name {
+				lastOfName = lineNo
+			}
+			if m == nil || m[1] != entry.Name || m[3] != entry.Index {
+				continue
+			}
+
+			found = true
+			if m[4] != entry.Value {
+				changes = append(changes, EntryChange{Entry: entry, OldValue: m[4]})
+				lines[lineNo] = entry.String()
+			}
+		}
+
+		if !found {
+			changes = append(changes, EntryChange{Entry: entry, Added: true})
+			if lastOfName != -1 {
+				// keep indexed entries of the same name together
+				lines = slices.Insert(lines, lastOfName+1, entry.String())
+			} else {
+				if inSection {
+					lines = append(lines, "["+SectionEnd+"]")
+				}
+				lines = append(lines, entry.String())
+			}
+		}
+	}
+
+	if dryRun || len(changes) == 0 {
+		return changes, nil
+	}
+
+	err = os.WriteFile(confFile, []byte(strings.Join(lines, "\n")+"\n"), 0666)
+	if err != nil {
+		return nil, fmt.Errorf("unable to write file %v: %v", confFile, err)
+	}
+
+	return changes, nil
+}
@@ -0,0 +1,286 @@
+package firmware
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Only files with this extension are considered firmware images
+const ImageExtension = ".img"
+
+type catalogImage struct {
+	Info    *FirmwareInfo
+	Err     error
+	Size    int64
+	ModTime time.Time
+	// zero if the image has no manifest
+	ManifestModTime time.Time
+}
+
+// Catalog indexes all firmware images in a directory.
+// Images are only parsed again if their size or modification time (or those of their manifest) changed.
+// Images not matching their manifest are never offered.
+type Catalog struct {
+	Dir string
+
+	mu     sync.Mutex
+	images map[string]*catalogImage
+	// images without manifest are unusable
+	requireManifest bool
+	// minimum version a phone must run before it can be updated to an image, by file name
+	minSources map[string]FirmwareVersion
+}
+
+// DeviceKey normalizes a device type like "OpenStage 40" to "openstage40"
+func DeviceKey(devType string) string {
+	return strings.ReplaceAll(strings.ToLower(devType), " ", "")
+}
+
+func NewCatalog(dir string) *Catalog {
+	return &Catalog{Dir: dir, images: make(map[string]*catalogImage), minSources: make(map[string]FirmwareVersion)}
+}
+
+// SetRequireManifest controls whether images without a manifest are usable
+func (cat *Catalog) SetRequireManifest(require bool) {
+	cat.mu.Lock()
+	defer cat.mu.Unlock()
+
+	cat.requireManifest = require
+}
+
+// imageErr returns why an image is unusable (or nil); must be called with cat.mu held
+func (cat *Catalog) imageErr(name string, image *catalogImage) error {
+	if image.Err != nil {
+		return image.Err
+	}
+	if cat.requireManifest && image.ManifestModTime.IsZero() {
+		return fmt.Errorf("image %v has no manifest %v", name, name+ManifestExtension)
+	}
+	return nil
+}
+
+// loadImage parses an image and verifies it against its manifest (if any)
+func loadImage(file string, hasManifest bool) (*FirmwareInfo, error) {
+	info, err := GetFirmwareInfo(file)
+	if err != nil || !hasManifest {
+		return info, err
+	}
+
+	manifest, err := LoadManifest(ManifestFile(file))
+	if err != nil {
+		return nil, err
+	}
+	err = manifest.Verify(*info)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// SetMinSources declares the upgrade paths: for each image (by file name), the minimum
+// version a phone must run before it can be updated to it
+func (cat *Catalog) SetMinSources(minSources map[string]FirmwareVersion) {
+	cat.mu.Lock()
+	defer cat.mu.Unlock()
+
+	cat.minSources = minSources
+}
+
+// MinSource returns the minimum version required to update to the image (or nil)
+func (cat *Catalog) MinSource(info FirmwareInfo) *FirmwareVersion {
+	cat.mu.Lock()
+	defer cat.mu.Unlock()
+
+	if ver, ok := cat.minSources[filepath.Base(info.File)]; ok {
+		return &ver
+	}
+	return nil
+}
+
+// canUpdate returns whether a phone running from can be updated to the image directly
+func (cat *Catalog) canUpdate(from FirmwareVersion, info FirmwareInfo) bool {
+	minSource := cat.MinSource(info)
+	return minSource == nil || from.Compare(*minSource) >= 0
+}
+
+// UpgradePath returns the images a phone running from must be updated to (in this order)
+// to reach target. Unless required by the declared minimum source versions, this is just target.
+// Each intermediate step is the newest image which can be reached directly.
+func (cat *Catalog) UpgradePath(devType string, fwType string, from FirmwareVersion, target FirmwareInfo) ([]FirmwareInfo, error) {
+	path := make([]FirmwareInfo, 0)
+	candidates := cat.Find(devType, fwType)
+
+	current := from
+	for !cat.canUpdate(current, target) {
+		var next *FirmwareInfo
+		// candidates are ordered newest first
+		for idx, candidate := range candidates {
+			if candidate.FwVersion.Compare(current) > 0 && candidate.FwVersion.Compare(target.FwVersion) < 0 && cat.canUpdate(current, candidate) {
+				next = &candidates[idx]
+				break
+			}
+		}
+
+		if next == nil {
+			return nil, fmt.Errorf("no upgrade path from %v to %v (requires at least %v)", current, target.FwVersion, *cat.MinSource(target))
+		}
+
+		path = append(path, *next)
+		current = next.FwVersion
+	}
+
+	return append(path, target), nil
+}
+
+// Refresh rescans the directory and returns the names of all files which changed
+func (cat *Catalog) Refresh() ([]string, error) {
+	cat.mu.Lock()
+	defer cat.mu.Unlock()
+
+	dirEntries, err := os.ReadDir(cat.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read firmware directory %v: %v", cat.Dir, err)
+	}
+
+	changed := make([]string, 0)
+	seen := make(map[string]bool)
+
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		if dirEntry.IsDir() || filepath.Ext(name) != ImageExtension {
+			continue
+		}
+		seen[name] = true
+
+		fi, err := dirEntry.Info()
+		if err != nil {
+			continue
+		}
+
+		var manifestModTime time.Time
+		if mfi, err := os.Stat(filepath.Join(cat.Dir, name+ManifestExtension)); err == nil {
+			manifestModTime = mfi.ModTime()
+		}
+
+		image, ok := cat.images[name]
+		if ok && image.Size == fi.Size() && image.ModTime.Equal(fi.ModTime()) && image.ManifestModTime.Equal(manifestModTime) {
+			continue
+		}
+
+		image = &catalogImage{Size: fi.Size(), ModTime: fi.ModTime(), ManifestModTime: manifestModTime}
+		image.Info, image.Err = loadImage(filepath.Join(cat.Dir, name), !manifestModTime.IsZero())
+		cat.images[name] = image
+		changed = append(changed, name)
+	}
+
+	for name := range cat.images {
+		if !seen[name] {
+			delete(cat.images, name)
+			changed = append(changed, name)
+		}
+	}
+
+	return changed, nil
+}
+
+// Images returns all valid images, ordered by device type, firmware type and descending version
+func (cat *Catalog) Images() []FirmwareInfo {
+	cat.mu.Lock()
+	defer cat.mu.Unlock()
+
+	res := make([]FirmwareInfo, 0)
+	for name, image := range cat.images {
+		if cat.imageErr(name, image) == nil {
+			res = append(res, *image.Info)
+		}
+	}
+
+	slices.SortFunc(res, func(a, b FirmwareInfo) int {
+		if c := strings.Compare(DeviceKey(a.DevType), DeviceKey(b.DevType)); c != 0 {
+			return c
+		}
+		if c := strings.Compare(a.FwType, b.FwType); c != 0 {
+			return c
+		}
+		if c := b.FwVersion.Compare(a.FwVersion); c != 0 {
+			return c
+		}
+		return strings.Compare(a.File, b.File)
+	})
+
+	return res
+}
+
+// Invalid returns all files which could not be parsed as firmware image or failed verification
+func (cat *Catalog) Invalid() map[string]error {
+	cat.mu.Lock()
+	defer cat.mu.Unlock()
+
+	res := make(map[string]error)
+	for name, image := range cat.images {
+		if err := cat.imageErr(name, image); err != nil {
+			res[name] = err
+		}
+	}
+	return res
+}
+
+// Find returns all images for the device and firmware type, newest first
+func (cat *Catalog) Find(devType string, fwType string) []FirmwareInfo {
+	res := make([]FirmwareInfo, 0)
+	for _, info := range cat.Images() {
+		if info.CheckDeployable(devType, fwType) == nil {
+			res = append(res, info)
+		}
+	}
+	return res
+}
+
+// Latest returns the newest image for the device and firmware type
+func (cat *Catalog) Latest(devType string, fwType string) (*FirmwareInfo, error) {
+	images := cat.Find(devType, fwType)
+	if len(images) == 0 {
+		return nil, fmt.Errorf("no %v firmware for %v in %v", fwType, devType, cat.Dir)
+	}
+	return &images[0], nil
+}
+
+// Version returns the image with exactly the given version for the device and firmware type
+func (cat *Catalog) Version(devType string, fwType string, version FirmwareVersion) (*FirmwareInfo, error) {
+	for _, info := range cat.Find(devType, fwType) {
+		if info.FwVersion.Compare(version) == 0 {
+			return &info, nil
+		}
+	}
+	return nil, fmt.Errorf("no %v firmware %v for %v in %v", fwType, version, devType, cat.Dir)
+}
+
+// File returns the image stored in the given file of the catalog directory
+func (cat *Catalog) File(name string) (*FirmwareInfo, error) {
+	cat.mu.Lock()
+	defer cat.mu.Unlock()
+
+	image, ok := cat.images[name]
+	if !ok {
+		return nil, fmt.Errorf("no firmware image %v in %v", name, cat.Dir)
+	}
+	if err := cat.imageErr(name, image); err != nil {
+		return nil, err
+	}
+	return image.Info, nil
+}
+
+// IsStale returns whether there is a newer image for the same device and firmware type
+func (cat *Catalog) IsStale(info FirmwareInfo) bool {
+	for _, other := range cat.Images() {
+		if other.IsCompatible(info) && other.IsSIP() == info.IsSIP() && other.FwVersion.Compare(info.FwVersion) > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -7,7 +7,7 @@ import (
 	"strings"
 )
 
-type firmwareInfo struct {
+type FirmwareInfo struct {
 	File      string
 	Phone     string
 	DevType   string
@@ -15,27 +15,56 @@ type firmwareInfo struct {
 	FwVersion FirmwareVersion
 }
 
-func (info firmwareInfo) IsCompatible(info2 firmwareInfo) bool {
-	return info.Phone == info2.Phone && info.DevType == info2.DevType
+const (
+	FwTypeSIP = "Siemens SIP"
+	FwTypeHFA = "Siemens HFA"
+)
+
+func (info FirmwareInfo) IsCompatible(info2 FirmwareInfo) bool {
+	return DeviceKey(info.Phone) == DeviceKey(info2.Phone) && DeviceKey(info.DevType) == DeviceKey(info2.DevType)
+}
+
+func (info FirmwareInfo) IsSIP() bool {
+	return info.FwType == FwTypeSIP
 }
 
-func (info firmwareInfo) IsSIP() bool {
-	return info.FwType == "Siemens SIP"
+// CheckDeployable returns an error if the image must not be deployed to a phone
+// reporting the given device-type and software-type
+func (info FirmwareInfo) CheckDeployable(devType string, fwType string) error {
+	if fwType != FwTypeSIP && fwType != FwTypeHFA {
+		return fmt.Errorf("phone reports unknown software type '%v'", fwType)
+	}
+
+	// the phone doesn't report the model family; it's part of its device type, though
+	phone := FirmwareInfo{Phone: devType, DevType: devType, FwType: fwType}
+	if strings.HasPrefix(DeviceKey(devType), DeviceKey(info.Phone)) {
+		phone.Phone = info.Phone
+	}
+
+	if !info.IsCompatible(phone) {
+		return fmt.Errorf("image %v is for %v, not for %v", info.File, info.DevType, devType)
+	}
+
+	if info.IsSIP() != phone.IsSIP() {
+		return fmt.Errorf("image %v contains %v firmware, but the phone runs %v", info.File, info.FwType, fwType)
+	}
+
+	return nil
 }
 
 func stringFromReader(reader *bufio.Reader, file string, desc string) (string, error) {
 	str, err := reader.ReadString(0)
 	if err != nil {
-		return "", fmt.Errorf("failed to read %v from %v: %v\n", desc, file, err)
+		return "", fmt.Errorf("failed to read %v from %v: %v", desc, file, err)
 	}
 	str = strings.Trim(str, string("\x00"))
 	return str, nil
 }
 
-func GetFirmwareInfo(file string) (*firmwareInfo, error) {
+func GetFirmwareInfo(file string) (*FirmwareInfo, error) {
 	f, err := os.Open(file)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open firmware file %v: %v\n", file, err)
+		return nil, fmt.Errorf("failed to open firmware file %v: %v", file, err)
 	}
 	defer f.Close()
 
@@ -43,7 +72,7 @@ func GetFirmwareInfo(file string) (*firmwareInfo, error) {
 
 	_, err = reader.Discard(0x20)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to read header of %v: %v", file, err)
 	}
 
 	phone, err := stringFromReader(reader, file, "phone info")
@@ -55,7 +84,7 @@ func GetFirmwareInfo(file string) (*firmwareInfo, error) {
 	for {
 		b, err := reader.ReadByte()
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("failed to read version info from %v: %v", file, err)
 		}
 		if b != 0 {
 			break
@@ -74,7 +103,7 @@ func GetFirmwareInfo(file string) (*firmwareInfo, error) {
 
 	_, err = f.Seek(-0x128, 2)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to read trailer of %v: %v", file, err)
 	}
 
 	reader.Reset(f)
@@ -94,8 +123,8 @@ func GetFirmwareInfo(file string) (*firmwareInfo, error) {
 		return nil, err
 	}
 
-	if fwType != "Siemens SIP" && fwType != "Siemens HFA" {
-		return nil, fmt.Errorf("unknown device type'%v' - is the file a firmware image", fwType)
+	if fwType != FwTypeSIP && fwType != FwTypeHFA {
+		return nil, fmt.Errorf("unknown firmware type '%v' - is the file a firmware image?", fwType)
 	}
 
 	ver, err := ParseFirmwareVersion(version)
@@ -103,6 +132,6 @@ func GetFirmwareInfo(file string) (*firmwareInfo, error) {
 		return nil, fmt.Errorf("failed to parse firmware version '%v': %v", version, err)
 	}
 
-	info := firmwareInfo{File: file, Phone: phone, DevType: devType, FwType: fwType, FwVersion: *ver}
+	info := FirmwareInfo{File: file, Phone: phone, DevType: devType, FwType: fwType, FwVersion: *ver}
 	return &info, nil
 }
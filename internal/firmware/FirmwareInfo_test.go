@@ -0,0 +1,45 @@
+package firmware
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckDeployable(t *testing.T) {
+	image := FirmwareInfo{File: "os40_sip.img", Phone: "OpenStage", DevType: "OpenStage 40", FwType: FwTypeSIP}
+
+	tests := []struct {
+		name    string
+		image   FirmwareInfo
+		devType string
+		fwType  string
+		err     string
+	}{
+		{"same device", image, "OpenStage 40", FwTypeSIP, ""},
+		{"spelling of device type", image, "openstage40", FwTypeSIP, ""},
+		{"other model", image, "OpenStage 60", FwTypeSIP, "not for OpenStage 60"},
+		{"other family", image, "OpenScape Desk Phone IP 55G", FwTypeSIP, "not for OpenScape"},
+		{"family prefix only", image, "OpenStage", FwTypeSIP, "not for OpenStage"},
+		{"HFA phone", image, "OpenStage 40", FwTypeHFA, "the phone runs Siemens HFA"},
+		{"HFA image", FirmwareInfo{File: "os40_hfa.img", Phone: "OpenStage", DevType: "OpenStage 40", FwType: FwTypeHFA}, "OpenStage 40", FwTypeSIP, "contains Siemens HFA firmware"},
+		{"unknown software type", image, "OpenStage 40", "Other", "unknown software type"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.image.CheckDeployable(tt.devType, tt.fwType)
+			if tt.err == "" && err != nil {
+				t.Errorf("CheckDeployable: %v", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Errorf("CheckDeployable error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+
+	// deployable images are the compatible ones of Catalog.IsStale
+	phone := FirmwareInfo{Phone: "OpenStage", DevType: "openstage40", FwType: FwTypeSIP}
+	if image.CheckDeployable(phone.DevType, phone.FwType) != nil || !image.IsCompatible(phone) {
+		t.Errorf("CheckDeployable and IsCompatible disagree")
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"strconv"
 )
 
+var versionRx = regexp.MustCompile(`V(\d+)(\.(\d+))? R(\d+)\.(\d+)\.(\d+)`)
+
 type FirmwareVersion struct {
 	Major    int
 	Submajor int
@@ -48,8 +50,10 @@ func (v FirmwareVersion) String() string {
 func ParseFirmwareVersion(version string) (*FirmwareVersion, error) {
 	// try to parse firmware version
 	// Vx[.y] Rm.f.h
-	rx := regexp.MustCompile(`V(\d+)(\.(\d+))? R(\d+)\.(\d+)\.(\d+)`)
-	m := rx.FindAllStringSubmatch(version, -1)
+	m := versionRx.FindAllStringSubmatch(version, -1)
+	if m == nil {
+		return nil, fmt.Errorf("'%v' is not a firmware version like Vx[.y] Rm.f.h", version)
+	}
 
 	major, err := strconv.Atoi(m[0][1])
 	if err != nil {
@@ -0,0 +1,119 @@
+package firmware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Sidecar manifests are stored next to the image, e.g. os40_v3r5.img.manifest
+const ManifestExtension = ".manifest"
+
+// Manifest records what an image is expected to contain, to detect truncated or replaced uploads
+type Manifest struct {
+	File    string `json:"file"`
+	Size    int64  `json:"size"`
+	SHA256  string `json:"sha256"`
+	Version string `json:"version"`
+	DevType string `json:"device-type"`
+	FwType  string `json:"software-type"`
+}
+
+// ManifestFile returns the path of the manifest belonging to an image
+func ManifestFile(image string) string {
+	return image + ManifestExtension
+}
+
+func hashFile(file string) (int64, string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to open %v: %v", file, err)
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to read %v: %v", file, err)
+	}
+
+	return size, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// NewManifest creates the manifest of an image
+func NewManifest(info FirmwareInfo) (*Manifest, error) {
+	size, sum, err := hashFile(info.File)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Manifest{
+		File:    filepath.Base(info.File),
+		Size:    size,
+		SHA256:  sum,
+		Version: info.FwVersion.String(),
+		DevType: info.DevType,
+		FwType:  info.FwType,
+	}, nil
+}
+
+// LoadManifest reads a manifest file
+func LoadManifest(file string) (*Manifest, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read manifest %v: %v", file, err)
+	}
+
+	var manifest Manifest
+	err = json.Unmarshal(content, &manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %v: %v", file, err)
+	}
+
+	return &manifest, nil
+}
+
+// Write stores the manifest in file
+func (manifest Manifest) Write(file string) error {
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(file, append(content, '\n'), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write manifest %v: %v", file, err)
+	}
+	return nil
+}
+
+// Verify checks the image against the manifest: size and checksum of the file as well as the
+// version and device type read from its trailer must match
+func (manifest Manifest) Verify(info FirmwareInfo) error {
+	size, sum, err := hashFile(info.File)
+	if err != nil {
+		return err
+	}
+	if size != manifest.Size {
+		return fmt.Errorf("image %v has %v bytes, manifest expects %v (truncated upload?)", info.File, size, manifest.Size)
+	}
+	if sum != manifest.SHA256 {
+		return fmt.Errorf("image %v has SHA-256 %v, manifest expects %v", info.File, sum, manifest.SHA256)
+	}
+
+	if manifest.Version != info.FwVersion.String() {
+		return fmt.Errorf("image %v contains version %v, manifest expects %v", info.File, info.FwVersion, manifest.Version)
+	}
+	if DeviceKey(manifest.DevType) != DeviceKey(info.DevType) {
+		return fmt.Errorf("image %v is for %v, manifest expects %v", info.File, info.DevType, manifest.DevType)
+	}
+	if manifest.FwType != "" && manifest.FwType != info.FwType {
+		return fmt.Errorf("image %v contains %v firmware, manifest expects %v", info.File, info.FwType, manifest.FwType)
+	}
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package media
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	_ "image/png"
+	"io"
+
+	"github.com/zam-haus/dlsir/internal/bitmap"
+)
+
+// BMPSize returns the dimensions stored in the header of a Windows bitmap
+func BMPSize(r io.Reader) (int, int, error) {
+	var header struct {
+		Magic      [2]byte
+		FileSize   uint32
+		Reserved   uint32
+		Offset     uint32
+		HeaderSize uint32
+		Width      int32
+		Height     int32
+	}
+	err := binary.Read(r, binary.LittleEndian, &header)
+	if err != nil || string(header.Magic[:]) != "BM" {
+		return 0, 0, fmt.Errorf("not a BMP file")
+	}
+
+	height := header.Height
+	if height < 0 {
+		// top-down bitmap
+		height = -height
+	}
+	return int(header.Width), int(height), nil
+}
+
+// ConvertImage decodes an image (PNG) and scales it to fit into width x height, keeping its
+// aspect ratio. Transparent areas and the margins are filled with the background color.
+func ConvertImage(r io.Reader, width int, height int, background bitmap.Color) (*bitmap.Image, error) {
+	src, _, err := image.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %v", err)
+	}
+
+	bounds := src.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+	if srcWidth == 0 || srcHeight == 0 {
+		return nil, fmt.Errorf("image is empty")
+	}
+
+	// fit into the target, keeping the aspect ratio
+	scale := min(float64(width)/float64(srcWidth), float64(height)/float64(srcHeight))
+	dstWidth := max(1, int(float64(srcWidth)*scale+0.5))
+	dstHeight := max(1, int(float64(srcHeight)*scale+0.5))
+	left := (width - dstWidth) / 2
+	top := (height - dstHeight) / 2
+
+	dst := bitmap.New(width, height, background)
+	for y := 0; y < dstHeight; y++ {
+		// source rows covered by this pixel (at least one)
+		y0 := bounds.Min.Y + y*srcHeight/dstHeight
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*srcHeight/dstHeight)
+
+		for x := 0; x < dstWidth; x++ {
+			x0 := bounds.Min.X + x*srcWidth/dstWidth
+			x1 := max(x0+1, bounds.Min.X+(x+1)*srcWidth/dstWidth)
+
+			dst.Set(left+x, top+y, averageArea(src, x0, y0, x1, y1, background))
+		}
+	}
+
+	return dst, nil
+}
+
+// averageArea returns the mean color of the source rectangle, composited onto the background
+func averageArea(src image.Image, x0 int, y0 int, x1 int, y1 int, background bitmap.Color) bitmap.Color {
+	var r, g, b float64
+	n := float64((x1 - x0) * (y1 - y0))
+
+	for y := y0; y < y1; y++ {
+		for x := x0; x < x1; x++ {
+			// premultiplied 16 bit components
+			sr, sg, sb, sa := src.At(x, y).RGBA()
+			inv := float64(0xffff-sa) / 0xffff
+			r += float64(sr)/0xffff*255 + float64(background.R)*inv
+			g += float64(sg)/0xffff*255 + float64(background.G)*inv
+			b += float64(sb)/0xffff*255 + float64(background.B)*inv
+		}
+	}
+
+	return bitmap.Color{R: uint8(r/n + 0.5), G: uint8(g/n + 0.5), B: uint8(b/n + 0.5)}
+}
@@ -0,0 +1,170 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/zam-haus/dlsir/internal/bitmap"
+)
+
+func TestBMPSize(t *testing.T) {
+	img := bitmap.New(12, 7, bitmap.Color{})
+	var buf bytes.Buffer
+	if err := img.EncodeBMP(&buf); err != nil {
+		t.Fatal(err)
+	}
+	bottomUp := buf.Bytes()
+
+	// the same header with a negative height
+	topDown := bytes.Clone(bottomUp)
+	height := int32(-7)
+	binary.LittleEndian.PutUint32(topDown[22:], uint32(height))
+
+	tests := []struct {
+		name   string
+		data   []byte
+		width  int
+		height int
+	}{
+		{"bottom-up", bottomUp, 12, 7},
+		{"top-down", topDown, 12, 7},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			width, height, err := BMPSize(bytes.NewReader(tt.data))
+			if err != nil {
+				t.Fatalf("BMPSize: %v", err)
+			}
+			if width != tt.width || height != tt.height {
+				t.Errorf("BMPSize = %vx%v, want %vx%v", width, height, tt.width, tt.height)
+			}
+		})
+	}
+
+	for _, data := range [][]byte{[]byte("PNG"), append([]byte("XX"), bottomUp[2:]...)} {
+		if _, _, err := BMPSize(bytes.NewReader(data)); err == nil {
+			t.Errorf("BMPSize accepted %q", data[:2])
+		}
+	}
+}
+
+func encodePNG(t *testing.T, img image.Image) *bytes.Reader {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+// fill returns an image of the given size and color
+func fill(width int, height int, c color.Color) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestConvertImage(t *testing.T) {
+	background := bitmap.Color{R: 0, G: 0, B: 255}
+	red := color.NRGBA{R: 255, A: 255}
+
+	tests := []struct {
+		name   string
+		src    image.Image
+		width  int
+		height int
+		// the color at each point
+		want map[image.Point]bitmap.Color
+	}{
+		{
+			name:  "same size",
+			src:   fill(4, 4, red),
+			width: 4, height: 4,
+			want: map[image.Point]bitmap.Color{{0, 0}: {R: 255}, {3, 3}: {R: 255}},
+		},
+		{
+			// 8x4 scaled to 8x4 in 8x8: margins of 2 rows at the top and bottom
+			name:  "letterbox",
+			src:   fill(8, 4, red),
+			width: 8, height: 8,
+			want: map[image.Point]bitmap.Color{
+				{0, 0}: background, {7, 1}: background,
+				{0, 2}: {R: 255}, {7, 5}: {R: 255},
+				{0, 6}: background, {7, 7}: background,
+			},
+		},
+		{
+			// 4x8 scaled down to 2x4 in 8x4: margins of 3 columns on the left and right
+			name:  "pillarbox",
+			src:   fill(4, 8, red),
+			width: 8, height: 4,
+			want: map[image.Point]bitmap.Color{
+				{2, 0}: background, {3, 0}: {R: 255}, {4, 3}: {R: 255}, {5, 3}: background,
+			},
+		},
+		{
+			name:  "transparent",
+			src:   fill(2, 2, color.NRGBA{R: 255, A: 0}),
+			width: 2, height: 2,
+			want: map[image.Point]bitmap.Color{{0, 0}: background, {1, 1}: background},
+		},
+		{
+			name:  "half transparent",
+			src:   fill(2, 2, color.NRGBA{R: 255, A: 128}),
+			width: 2, height: 2,
+			want: map[image.Point]bitmap.Color{{0, 0}: {R: 128, B: 127}},
+		},
+		{
+			// 2x2 to 1x1: the average of the pixels
+			name:  "downscaled",
+			src:   checkerboard(),
+			width: 1, height: 1,
+			want: map[image.Point]bitmap.Color{{0, 0}: {R: 128, G: 128, B: 128}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := ConvertImage(encodePNG(t, tt.src), tt.width, tt.height, background)
+			if err != nil {
+				t.Fatalf("ConvertImage: %v", err)
+			}
+
+			var buf bytes.Buffer
+			if err := img.EncodeBMP(&buf); err != nil {
+				t.Fatal(err)
+			}
+			if width, height, _ := BMPSize(&buf); width != tt.width || height != tt.height {
+				t.Errorf("size = %vx%v, want %vx%v", width, height, tt.width, tt.height)
+			}
+
+			for pt, want := range tt.want {
+				if got := img.At(pt.X, pt.Y); got != want {
+					t.Errorf("At(%v, %v) = %v, want %v", pt.X, pt.Y, got, want)
+				}
+			}
+		})
+	}
+
+	if _, err := ConvertImage(bytes.NewReader([]byte("not an image")), 4, 4, background); err == nil {
+		t.Errorf("ConvertImage accepted an invalid image")
+	}
+}
+
+// checkerboard returns a 2x2 image with black and white pixels
+func checkerboard() *image.NRGBA {
+	img := fill(2, 2, color.NRGBA{A: 255})
+	img.Set(0, 0, color.NRGBA{R: 255, G: 255, B: 255, A: 255})
+	img.Set(1, 1, color.NRGBA{R: 255, G: 255, B: 255, A: 255})
+	return img
+}
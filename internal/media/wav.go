@@ -0,0 +1,236 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// AudioFormat describes PCM audio
+type AudioFormat struct {
+	SampleRate int
+	Bits       int
+	Channels   int
+}
+
+func (format AudioFormat) String() string {
+	return fmt.Sprintf("%v Hz, %v bit, %v channel(s)", format.SampleRate, format.Bits, format.Channels)
+}
+
+// Audio is decoded mono audio with samples in the range -1..1
+type Audio struct {
+	SampleRate int
+	Samples    []float64
+}
+
+const wavFormatPCM = 1
+
+type wavFmtChunk struct {
+	AudioFormat   uint16
+	Channels      uint16
+	SampleRate    uint32
+	ByteRate      uint32
+	BlockAlign    uint16
+	BitsPerSample uint16
+}
+
+// DecodeWAV reads an uncompressed PCM WAV file (8 or 16 bit, any number of channels).
+// Channels are mixed down to mono.
+func DecodeWAV(r io.Reader) (*Audio, AudioFormat, error) {
+	var riff struct {
+		ID   [4]byte
+		Size uint32
+		Type [4]byte
+	}
+	err := binary.Read(r, binary.LittleEndian, &riff)
+	if err != nil || string(riff.ID[:]) != "RIFF" || string(riff.Type[:]) != "WAVE" {
+		return nil, AudioFormat{}, fmt.Errorf("not a WAV file")
+	}
+
+	var format *wavFmtChunk
+	for {
+		var chunk struct {
+			ID   [4]byte
+			Size uint32
+		}
+		err = binary.Read(r, binary.LittleEndian, &chunk)
+		if err != nil {
+			return nil, AudioFormat{}, fmt.Errorf("WAV file has no data chunk")
+		}
+
+		// chunks are padded to an even size
+		size := int64(chunk.Size) + int64(chunk.Size%2)
+
+		switch string(chunk.ID[:]) {
+		case "fmt ":
+			format = &wavFmtChunk{}
+			err = binary.Read(io.LimitReader(r, size), binary.LittleEndian, format)
+			if err != nil {
+				return nil, AudioFormat{}, fmt.Errorf("invalid fmt chunk: %v", err)
+			}
+			_, err = io.CopyN(io.Discard, r, size-16)
+			if err != nil {
+				return nil, AudioFormat{}, fmt.Errorf("invalid fmt chunk: %v", err)
+			}
+		case "data":
+			if format == nil {
+				return nil, AudioFormat{}, fmt.Errorf("WAV data chunk before fmt chunk")
+			}
+			data := make([]byte, chunk.Size)
+			_, err = io.ReadFull(r, data)
+			if err != nil {
+				return nil, AudioFormat{}, fmt.Errorf("truncated WAV data: %v", err)
+			}
+			return decodePCM(data, format)
+		default:
+			_, err = io.CopyN(io.Discard, r, size)
+			if err != nil {
+				return nil, AudioFormat{}, fmt.Errorf("truncated WAV chunk %q", chunk.ID)
+			}
+		}
+	}
+}
+
+func decodePCM(data []byte, format *wavFmtChunk) (*Audio, AudioFormat, error) {
+	af := AudioFormat{SampleRate: int(format.SampleRate), Bits: int(format.BitsPerSample), Channels: int(format.Channels)}
+
+	if format.AudioFormat != wavFormatPCM {
+		return nil, af, fmt.Errorf("unsupported WAV encoding %v, only uncompressed PCM is supported", format.AudioFormat)
+	}
+	if af.Bits != 8 && af.Bits != 16 {
+		return nil, af, fmt.Errorf("unsupported sample size of %v bit", af.Bits)
+	}
+	if af.Channels < 1 || af.SampleRate <= 0 {
+		return nil, af, fmt.Errorf("invalid WAV format %v", af)
+	}
+
+	frameSize := af.Channels * af.Bits / 8
+	frames := len(data) / frameSize
+	audio := &Audio{SampleRate: af.SampleRate, Samples: make([]float64, frames)}
+
+	for frame := 0; frame < frames; frame++ {
+		sum := 0.0
+		for ch := 0; ch < af.Channels; ch++ {
+			offset := frame*frameSize + ch*af.Bits/8
+			if af.Bits == 8 {
+				// 8 bit samples are unsigned
+				sum += (float64(data[offset]) - 128) / 128
+			} else {
+				sum += float64(int16(binary.LittleEndian.Uint16(data[offset:]))) / 32768
+			}
+		}
+		audio.Samples[frame] = sum / float64(af.Channels)
+	}
+
+	return audio, af, nil
+}
+
+// Resample converts the audio to another sample rate using linear interpolation. When the rate
+// is lowered, frequencies above the new Nyquist frequency are filtered out first, so they don't
+// alias into audible tones.
+func (audio *Audio) Resample(rate int) *Audio {
+	if rate == audio.SampleRate || len(audio.Samples) == 0 {
+		return &Audio{SampleRate: audio.SampleRate, Samples: audio.Samples}
+	}
+
+	samples := audio.Samples
+	if rate < audio.SampleRate {
+		samples = lowPass(samples, 0.5*float64(rate)/float64(audio.SampleRate))
+	}
+
+	n := int(int64(len(samples)) * int64(rate) / int64(audio.SampleRate))
+	res := &Audio{SampleRate: rate, Samples: make([]float64, n)}
+	step := float64(audio.SampleRate) / float64(rate)
+
+	for idx := range res.Samples {
+		pos := float64(idx) * step
+		left := int(pos)
+		frac := pos - float64(left)
+
+		right := min(left+1, len(samples)-1)
+		res.Samples[idx] = samples[left]*(1-frac) + samples[right]*frac
+	}
+
+	return res
+}
+
+// lowPass filters out frequencies above cutoff (in cycles per sample, below 0.5) with a
+// windowed-sinc FIR filter
+func lowPass(samples []float64, cutoff float64) []float64 {
+	// three periods of the cutoff frequency on each side
+	half := int(math.Ceil(3 / cutoff))
+	taps := make([]float64, 2*half+1)
+	sum := 0.0
+	for idx := range taps {
+		x := float64(idx - half)
+		tap := 2 * cutoff
+		if x != 0 {
+			tap = math.Sin(2*math.Pi*cutoff*x) / (math.Pi * x)
+		}
+		// Hann window
+		tap *= 0.5 + 0.5*math.Cos(math.Pi*x/float64(half+1))
+		taps[idx] = tap
+		sum += tap
+	}
+
+	res := make([]float64, len(samples))
+	for idx := range samples {
+		acc := 0.0
+		for t, tap := range taps {
+			// the audio is silent outside of the samples
+			if pos := idx + t - half; pos >= 0 && pos < len(samples) {
+				acc += samples[pos] * tap
+			}
+		}
+		// normalized to a gain of 1 for DC
+		res[idx] = acc / sum
+	}
+	return res
+}
+
+// EncodeWAV writes the audio as mono PCM WAV with the given sample size (8 or 16 bit)
+func (audio *Audio) EncodeWAV(w io.Writer, bits int) error {
+	if bits != 8 && bits != 16 {
+		return fmt.Errorf("unsupported sample size of %v bit", bits)
+	}
+
+	var data bytes.Buffer
+	for _, sample := range audio.Samples {
+		sample = max(-1, min(1, sample))
+		if bits == 8 {
+			data.WriteByte(uint8(sample*127 + 128))
+		} else {
+			_ = binary.Write(&data, binary.LittleEndian, int16(sample*32767))
+		}
+	}
+
+	blockAlign := bits / 8
+	header := []any{
+		[4]byte{'R', 'I', 'F', 'F'},
+		uint32(4 + 8 + 16 + 8 + data.Len()),
+		[4]byte{'W', 'A', 'V', 'E'},
+		[4]byte{'f', 'm', 't', ' '},
+		uint32(16),
+		wavFmtChunk{
+			AudioFormat:   wavFormatPCM,
+			Channels:      1,
+			SampleRate:    uint32(audio.SampleRate),
+			ByteRate:      uint32(audio.SampleRate * blockAlign),
+			BlockAlign:    uint16(blockAlign),
+			BitsPerSample: uint16(bits),
+		},
+		[4]byte{'d', 'a', 't', 'a'},
+		uint32(data.Len()),
+	}
+	for _, field := range header {
+		err := binary.Write(w, binary.LittleEndian, field)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := w.Write(data.Bytes())
+	return err
+}
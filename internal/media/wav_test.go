@@ -0,0 +1,252 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+)
+
+type wavChunk struct {
+	id   string
+	data []byte
+}
+
+// buildWAV returns a WAV file of the chunks, padding them to an even size
+func buildWAV(chunks ...wavChunk) []byte {
+	var body bytes.Buffer
+	body.WriteString("WAVE")
+	for _, chunk := range chunks {
+		body.WriteString(chunk.id)
+		_ = binary.Write(&body, binary.LittleEndian, uint32(len(chunk.data)))
+		body.Write(chunk.data)
+		if len(chunk.data)%2 == 1 {
+			body.WriteByte(0)
+		}
+	}
+
+	var res bytes.Buffer
+	res.WriteString("RIFF")
+	_ = binary.Write(&res, binary.LittleEndian, uint32(body.Len()))
+	res.Write(body.Bytes())
+	return res.Bytes()
+}
+
+// fmtChunk returns a PCM fmt chunk; extra bytes are appended like in WAVEFORMATEX
+func fmtChunk(rate int, bits int, channels int, extra int) wavChunk {
+	var data bytes.Buffer
+	_ = binary.Write(&data, binary.LittleEndian, wavFmtChunk{
+		AudioFormat:   wavFormatPCM,
+		Channels:      uint16(channels),
+		SampleRate:    uint32(rate),
+		ByteRate:      uint32(rate * channels * bits / 8),
+		BlockAlign:    uint16(channels * bits / 8),
+		BitsPerSample: uint16(bits),
+	})
+	data.Write(make([]byte, extra))
+	return wavChunk{"fmt ", data.Bytes()}
+}
+
+func pcm16(samples ...int16) []byte {
+	var data bytes.Buffer
+	_ = binary.Write(&data, binary.LittleEndian, samples)
+	return data.Bytes()
+}
+
+func equalSamples(got []float64, want []float64, tolerance float64) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for idx := range got {
+		if math.Abs(got[idx]-want[idx]) > tolerance {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDecodeWAV(t *testing.T) {
+	tests := []struct {
+		name   string
+		wav    []byte
+		format AudioFormat
+		want   []float64
+	}{
+		{
+			name:   "16 bit mono",
+			wav:    buildWAV(fmtChunk(8000, 16, 1, 0), wavChunk{"data", pcm16(0, 16384, -16384, -32768)}),
+			format: AudioFormat{SampleRate: 8000, Bits: 16, Channels: 1},
+			want:   []float64{0, 0.5, -0.5, -1},
+		},
+		{
+			name:   "8 bit unsigned",
+			wav:    buildWAV(fmtChunk(8000, 8, 1, 0), wavChunk{"data", []byte{128, 192, 64, 0}}),
+			format: AudioFormat{SampleRate: 8000, Bits: 8, Channels: 1},
+			want:   []float64{0, 0.5, -0.5, -1},
+		},
+		{
+			name:   "stereo mixdown",
+			wav:    buildWAV(fmtChunk(44100, 16, 2, 0), wavChunk{"data", pcm16(16384, -16384, 16384, 0, -32768, -32768)}),
+			format: AudioFormat{SampleRate: 44100, Bits: 16, Channels: 2},
+			want:   []float64{0, 0.25, -1},
+		},
+		{
+			name: "padded chunks",
+			wav: buildWAV(
+				fmtChunk(16000, 8, 1, 2),
+				wavChunk{"LIST", []byte("odd")},
+				wavChunk{"data", []byte{128, 192, 64}},
+			),
+			format: AudioFormat{SampleRate: 16000, Bits: 8, Channels: 1},
+			want:   []float64{0, 0.5, -0.5},
+		},
+		{
+			name:   "partial frame",
+			wav:    buildWAV(fmtChunk(8000, 16, 2, 0), wavChunk{"data", pcm16(16384, 16384, 0)}),
+			format: AudioFormat{SampleRate: 8000, Bits: 16, Channels: 2},
+			want:   []float64{0.5},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			audio, format, err := DecodeWAV(bytes.NewReader(tt.wav))
+			if err != nil {
+				t.Fatalf("DecodeWAV: %v", err)
+			}
+			if format != tt.format {
+				t.Errorf("format = %v, want %v", format, tt.format)
+			}
+			if audio.SampleRate != tt.format.SampleRate {
+				t.Errorf("SampleRate = %v, want %v", audio.SampleRate, tt.format.SampleRate)
+			}
+			if !equalSamples(audio.Samples, tt.want, 1e-9) {
+				t.Errorf("Samples = %v, want %v", audio.Samples, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeWAVInvalid(t *testing.T) {
+	compressed := fmtChunk(8000, 16, 1, 0)
+	compressed.data[0] = 3
+
+	tests := []struct {
+		name string
+		wav  []byte
+		err  string
+	}{
+		{"not RIFF", []byte("RIFX\x00\x00\x00\x00WAVE"), "not a WAV file"},
+		{"no data", buildWAV(fmtChunk(8000, 16, 1, 0)), "no data chunk"},
+		{"data before fmt", buildWAV(wavChunk{"data", pcm16(0)}, fmtChunk(8000, 16, 1, 0)), "before fmt chunk"},
+		{"not PCM", buildWAV(compressed, wavChunk{"data", pcm16(0)}), "only uncompressed PCM"},
+		{"24 bit", buildWAV(fmtChunk(8000, 24, 1, 0), wavChunk{"data", []byte{0, 0, 0}}), "sample size"},
+		{"no channels", buildWAV(fmtChunk(8000, 16, 0, 0), wavChunk{"data", pcm16(0)}), "invalid WAV format"},
+		{"truncated data", buildWAV(fmtChunk(8000, 16, 1, 0), wavChunk{"data", pcm16(0, 0)})[:46], "truncated WAV data"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := DecodeWAV(bytes.NewReader(tt.wav))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("DecodeWAV error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestEncodeWAV(t *testing.T) {
+	audio := &Audio{SampleRate: 8000, Samples: []float64{0, 0.5, -0.5, 1, -1, 0.25}}
+
+	for _, bits := range []int{8, 16} {
+		var buf bytes.Buffer
+		err := audio.EncodeWAV(&buf, bits)
+		if err != nil {
+			t.Fatalf("EncodeWAV(%v): %v", bits, err)
+		}
+		if want := 44 + len(audio.Samples)*bits/8; buf.Len() != want {
+			t.Errorf("EncodeWAV(%v) wrote %v bytes, want %v", bits, buf.Len(), want)
+		}
+
+		decoded, format, err := DecodeWAV(&buf)
+		if err != nil {
+			t.Fatalf("DecodeWAV of EncodeWAV(%v): %v", bits, err)
+		}
+		if want := (AudioFormat{SampleRate: 8000, Bits: bits, Channels: 1}); format != want {
+			t.Errorf("EncodeWAV(%v) format = %v, want %v", bits, format, want)
+		}
+		// quantization error of 8 bit samples
+		if !equalSamples(decoded.Samples, audio.Samples, 1.0/100) {
+			t.Errorf("EncodeWAV(%v) round trip = %v, want %v", bits, decoded.Samples, audio.Samples)
+		}
+	}
+
+	// samples beyond -1..1 are clipped
+	var buf bytes.Buffer
+	clipped := &Audio{SampleRate: 8000, Samples: []float64{2, -2}}
+	if err := clipped.EncodeWAV(&buf, 16); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.Bytes()[44:]; !bytes.Equal(got, pcm16(32767, -32767)) {
+		t.Errorf("clipped samples = %v", got)
+	}
+
+	if err := audio.EncodeWAV(&buf, 24); err == nil {
+		t.Errorf("EncodeWAV accepted 24 bit")
+	}
+}
+
+// sine returns a second of a sine wave with the given frequency and amplitude 1
+func sine(rate int, freq float64) *Audio {
+	audio := &Audio{SampleRate: rate, Samples: make([]float64, rate)}
+	for idx := range audio.Samples {
+		audio.Samples[idx] = math.Sin(2 * math.Pi * freq * float64(idx) / float64(rate))
+	}
+	return audio
+}
+
+// rms returns the root mean square of the samples, leaving out the edges
+func rms(samples []float64) float64 {
+	samples = samples[len(samples)/10 : len(samples)*9/10]
+	sum := 0.0
+	for _, sample := range samples {
+		sum += sample * sample
+	}
+	return math.Sqrt(sum / float64(len(samples)))
+}
+
+func TestResample(t *testing.T) {
+	tests := []struct {
+		name string
+		from int
+		to   int
+		freq float64
+		rms  float64
+	}{
+		{"same rate", 8000, 8000, 440, math.Sqrt2 / 2},
+		{"downsample keeps low tone", 44100, 8000, 440, math.Sqrt2 / 2},
+		{"downsample keeps tone below nyquist", 48000, 8000, 3000, math.Sqrt2 / 2},
+		// 7 kHz would alias to 1 kHz at 8000 Hz
+		{"downsample removes tone above nyquist", 44100, 8000, 7000, 0},
+		{"upsample", 8000, 16000, 440, math.Sqrt2 / 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := sine(tt.from, tt.freq).Resample(tt.to)
+
+			if res.SampleRate != tt.to || len(res.Samples) != tt.to {
+				t.Fatalf("Resample = %v samples at %v Hz, want %v at %v Hz", len(res.Samples), res.SampleRate, tt.to, tt.to)
+			}
+			if got := rms(res.Samples); math.Abs(got-tt.rms) > 0.05 {
+				t.Errorf("RMS = %.3f, want %.3f", got, tt.rms)
+			}
+		})
+	}
+
+	empty := (&Audio{SampleRate: 44100}).Resample(8000)
+	if len(empty.Samples) != 0 {
+		t.Errorf("Resample of no samples = %v", empty.Samples)
+	}
+}
@@ -0,0 +1,288 @@
+// Package pkcs12 writes PKCS#12 (RFC 7292) files holding a private key and its certificate chain,
+// as understood by phones and most other consumers: the key is encrypted with
+// pbeWithSHAAnd3-KeyTripleDES-CBC, the file is protected by an HMAC-SHA1.
+package pkcs12
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"unicode/utf16"
+)
+
+var (
+	oidData                       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidCertBag                    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidPKCS8ShroudedKeyBag        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidCertTypeX509               = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidPBEWithSHAAnd3KeyTripleDES = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+	oidSHA1                       = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidFriendlyName               = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidLocalKeyID                 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+)
+
+const iterations = 2048
+
+// The structures follow RFC 7292. RawValue fields are written as they are,
+// so explicit tags are added by wrapExplicit.
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue
+}
+
+type pfx struct {
+	Version  int
+	AuthSafe contentInfo
+	MacData  macData
+}
+
+type digestInfo struct {
+	Algorithm algorithmIdentifier
+	Digest    []byte
+}
+
+type macData struct {
+	Mac        digestInfo
+	MacSalt    []byte
+	Iterations int `asn1:"optional,default:1"`
+}
+
+type algorithmIdentifier struct {
+	Algorithm  asn1.ObjectIdentifier
+	Parameters asn1.RawValue `asn1:"optional"`
+}
+
+type pbeParams struct {
+	Salt       []byte
+	Iterations int
+}
+
+type safeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue
+	Attributes []attribute `asn1:"set,optional"`
+}
+
+type attribute struct {
+	ID     asn1.ObjectIdentifier
+	Values asn1.RawValue
+}
+
+type certBag struct {
+	ID   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     algorithmIdentifier
+	EncryptedData []byte
+}
+
+// bmpString encodes s as big-endian UTF-16
+func bmpString(s string) []byte {
+	res := make([]byte, 0, 2*len(s))
+	for _, r := range utf16.Encode([]rune(s)) {
+		res = append(res, byte(r>>8), byte(r))
+	}
+	return res
+}
+
+// deriveKey implements the key derivation of RFC 7292, appendix B.2, with SHA-1.
+// id is 1 for keys, 2 for IVs and 3 for MAC keys.
+func deriveKey(id byte, password []byte, salt []byte, iterations int, size int) []byte {
+	const u, v = sha1.Size, 64
+
+	fill := func(data []byte) []byte {
+		if len(data) == 0 {
+			return nil
+		}
+		res := make([]byte, v*((len(data)+v-1)/v))
+		for idx := range res {
+			res[idx] = data[idx%len(data)]
+		}
+		return res
+	}
+
+	d := bytes.Repeat([]byte{id}, v)
+	i := append(fill(salt), fill(password)...)
+
+	res := make([]byte, 0, size+u)
+	for len(res) < size {
+		hash := sha1.Sum(append(d, i...))
+		a := hash[:]
+		for round := 1; round < iterations; round++ {
+			hash = sha1.Sum(a)
+			a = hash[:]
+		}
+		res = append(res, a...)
+
+		// I_j = (I_j + B + 1) mod 2^(8v) for every v-byte block of I, with B = A repeated to v bytes
+		b := fill(a)[:v]
+		for j := 0; j < len(i); j += v {
+			carry := 1
+			for k := v - 1; k >= 0; k-- {
+				sum := int(i[j+k]) + int(b[k]) + carry
+				i[j+k] = byte(sum)
+				carry = sum >> 8
+			}
+		}
+	}
+
+	return res[:size]
+}
+
+func randomBytes(size int) ([]byte, error) {
+	res := make([]byte, size)
+	_, err := rand.Read(res)
+	return res, err
+}
+
+func encryptKey(key any, password []byte) ([]byte, error) {
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	salt, err := randomBytes(8)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := des.NewTripleDESCipher(deriveKey(1, password, salt, iterations, 24))
+	if err != nil {
+		return nil, err
+	}
+	iv := deriveKey(2, password, salt, iterations, block.BlockSize())
+
+	// PKCS#7 padding
+	padding := block.BlockSize() - len(pkcs8)%block.BlockSize()
+	data := append(pkcs8, bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+
+	params, err := asn1.Marshal(pbeParams{Salt: salt, Iterations: iterations})
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm:     algorithmIdentifier{Algorithm: oidPBEWithSHAAnd3KeyTripleDES, Parameters: asn1.RawValue{FullBytes: params}},
+		EncryptedData: data,
+	})
+}
+
+// bagAttributes returns the friendly name and local key ID linking the certificate to the key
+func bagAttributes(name string, keyID []byte) ([]attribute, error) {
+	nameValue, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagBMPString, Bytes: bmpString(name)})
+	if err != nil {
+		return nil, err
+	}
+	keyIDValue, err := asn1.Marshal(keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	// attribute values are a SET of a single value
+	set := func(value []byte) asn1.RawValue {
+		return asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: value}
+	}
+	return []attribute{
+		{ID: oidFriendlyName, Values: set(nameValue)},
+		{ID: oidLocalKeyID, Values: set(keyIDValue)},
+	}, nil
+}
+
+// wrapExplicit wraps DER content in a [0] EXPLICIT tag
+func wrapExplicit(content []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: content}
+}
+
+// dataContentInfo wraps content in a ContentInfo of type data
+func dataContentInfo(content []byte) (contentInfo, error) {
+	octets, err := asn1.Marshal(content)
+	if err != nil {
+		return contentInfo{}, err
+	}
+	return contentInfo{ContentType: oidData, Content: wrapExplicit(octets)}, nil
+}
+
+// Encode returns a PKCS#12 file holding the key, its certificate and the CA certificates
+func Encode(key any, cert *x509.Certificate, caCerts []*x509.Certificate, name string, password string) ([]byte, error) {
+	// the password is zero terminated
+	encodedPassword := append(bmpString(password), 0, 0)
+
+	keyID := sha1.Sum(cert.Raw)
+	attributes, err := bagAttributes(name, keyID[:])
+	if err != nil {
+		return nil, err
+	}
+
+	// certificate bags: the phone's certificate first
+	certBags := make([]safeBag, 0)
+	for idx, c := range append([]*x509.Certificate{cert}, caCerts...) {
+		bag, err := asn1.Marshal(certBag{ID: oidCertTypeX509, Data: c.Raw})
+		if err != nil {
+			return nil, err
+		}
+		sb := safeBag{ID: oidCertBag, Value: wrapExplicit(bag)}
+		if idx == 0 {
+			sb.Attributes = attributes
+		}
+		certBags = append(certBags, sb)
+	}
+
+	encryptedKey, err := encryptKey(key, encodedPassword)
+	if err != nil {
+		return nil, err
+	}
+	keyBags := []safeBag{{ID: oidPKCS8ShroudedKeyBag, Value: wrapExplicit(encryptedKey), Attributes: attributes}}
+
+	authSafe := make([]contentInfo, 0)
+	for _, bags := range [][]safeBag{certBags, keyBags} {
+		content, err := asn1.Marshal(bags)
+		if err != nil {
+			return nil, err
+		}
+		ci, err := dataContentInfo(content)
+		if err != nil {
+			return nil, err
+		}
+		authSafe = append(authSafe, ci)
+	}
+
+	authSafeContent, err := asn1.Marshal(authSafe)
+	if err != nil {
+		return nil, err
+	}
+
+	macSalt, err := randomBytes(8)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha1.New, deriveKey(3, encodedPassword, macSalt, iterations, sha1.Size))
+	mac.Write(authSafeContent)
+
+	outer, err := dataContentInfo(authSafeContent)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := asn1.Marshal(pfx{
+		Version:  3,
+		AuthSafe: outer,
+		MacData: macData{
+			Mac:        digestInfo{Algorithm: algorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.NullRawValue}, Digest: mac.Sum(nil)},
+			MacSalt:    macSalt,
+			Iterations: iterations,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode PKCS#12: %v", err)
+	}
+	return res, nil
+}
@@ -0,0 +1,127 @@
+package pkcs12
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	xpkcs12 "golang.org/x/crypto/pkcs12"
+)
+
+func newCertificate(t *testing.T, commonName string, key *rsa.PrivateKey, parent *x509.Certificate, parentKey *rsa.PrivateKey) *x509.Certificate {
+	t.Helper()
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  parent == nil,
+		BasicConstraintsValid: true,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func newKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	caKey := newKey(t)
+	ca := newCertificate(t, "Test CA", caKey, nil, nil)
+	key := newKey(t)
+	cert := newCertificate(t, "00:1a:2b:3c:4d:5e", key, ca, caKey)
+
+	for _, password := range []string{"", "s3cret"} {
+		t.Run("password="+password, func(t *testing.T) {
+			// Decode only accepts a single certificate
+			p12, err := Encode(key, cert, nil, "phone", password)
+			if err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+
+			decodedKey, decodedCert, err := xpkcs12.Decode(p12, password)
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if !bytes.Equal(decodedCert.Raw, cert.Raw) {
+				t.Errorf("decoded certificate differs")
+			}
+			rsaKey, ok := decodedKey.(*rsa.PrivateKey)
+			if !ok || !rsaKey.Equal(key) {
+				t.Errorf("decoded key differs")
+			}
+
+			_, _, err = xpkcs12.Decode(p12, password+"wrong")
+			if err == nil {
+				t.Errorf("Decode accepted a wrong password")
+			}
+		})
+	}
+}
+
+func TestEncodeChain(t *testing.T) {
+	caKey := newKey(t)
+	ca := newCertificate(t, "Test CA", caKey, nil, nil)
+	key := newKey(t)
+	cert := newCertificate(t, "00:1a:2b:3c:4d:5e", key, ca, caKey)
+
+	for _, password := range []string{"", "s3cret"} {
+		t.Run("password="+password, func(t *testing.T) {
+			p12, err := Encode(key, cert, []*x509.Certificate{ca}, "phone", password)
+			if err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+
+			blocks, err := xpkcs12.ToPEM(p12, password)
+			if err != nil {
+				t.Fatalf("ToPEM: %v", err)
+			}
+
+			certs := make([][]byte, 0)
+			keys := 0
+			for _, block := range blocks {
+				switch block.Type {
+				case "CERTIFICATE":
+					certs = append(certs, block.Bytes)
+				case "PRIVATE KEY":
+					keys++
+					decodedKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+					if err != nil || !decodedKey.Equal(key) {
+						t.Errorf("decoded key differs: %v", err)
+					}
+				}
+			}
+
+			if keys != 1 {
+				t.Errorf("found %v keys, want 1", keys)
+			}
+			if len(certs) != 2 || !bytes.Equal(certs[0], cert.Raw) || !bytes.Equal(certs[1], ca.Raw) {
+				t.Errorf("found %v certificates, want the client and the CA certificate", len(certs))
+			}
+		})
+	}
+}
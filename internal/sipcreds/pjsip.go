@@ -0,0 +1,232 @@
+package sipcreds
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+type pjsipOption struct {
+	Key   string
+	Value string
+}
+
+type pjsipSection struct {
+	Name       string
+	IsTemplate bool
+	Templates  []string
+	Options    []pjsipOption
+}
+
+var sectionRx = regexp.MustCompile(`^\[([^\]]+)\]\s*(\(([^)]*)\))?$`)
+var callerIDRx = regexp.MustCompile(`<([^>]+)>`)
+
+// pjsipSections indexes the sections by name. Asterisk allows several sections of the same
+// name as long as their types differ, e.g. [4242] with type=endpoint, type=auth and type=aor.
+type pjsipSections map[string][]*pjsipSection
+
+// template returns the section a template reference resolves to, preferring templates
+func (sections pjsipSections) template(name string) *pjsipSection {
+	candidates := sections[name]
+	for i := len(candidates) - 1; i >= 0; i-- {
+		if candidates[i].IsTemplate {
+			return candidates[i]
+		}
+	}
+	if len(candidates) > 0 {
+		return candidates[len(candidates)-1]
+	}
+	return nil
+}
+
+// find returns the section with the given name and type; templates are ignored
+func (sections pjsipSections) find(name string, sectionType string) *pjsipSection {
+	candidates := sections[name]
+	for i := len(candidates) - 1; i >= 0; i-- {
+		if !candidates[i].IsTemplate && candidates[i].get(sections, "type") == sectionType {
+			return candidates[i]
+		}
+	}
+	return nil
+}
+
+// get returns the last value of key, including values inherited from templates
+func (section *pjsipSection) get(sections pjsipSections, key string) string {
+	return section.lookup(sections, key, make(map[*pjsipSection]bool))
+}
+
+// lookup implements get; visited holds the sections already searched, so templates referencing
+// each other don't recurse forever
+func (section *pjsipSection) lookup(sections pjsipSections, key string, visited map[*pjsipSection]bool) string {
+	visited[section] = true
+
+	for i := len(section.Options) - 1; i >= 0; i-- {
+		if section.Options[i].Key == key {
+			return section.Options[i].Value
+		}
+	}
+
+	for i := len(section.Templates) - 1; i >= 0; i-- {
+		tpl := sections.template(section.Templates[i])
+		if tpl == nil || visited[tpl] {
+			continue
+		}
+
+		if value := tpl.lookup(sections, key, visited); value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
+
+func stripComment(line string) string {
+	if idx := strings.Index(line, ";"); idx != -1 {
+		line = line[:idx]
+	}
+	return strings.TrimSpace(line)
+}
+
+func readPjsipFile(file string, sections pjsipSections, order *[]*pjsipSection, depth int) error {
+	if depth > 10 {
+		return fmt.Errorf("too many nested includes in %v", file)
+	}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("unable to read file %v: %v", file, err)
+	}
+
+	var current *pjsipSection
+	inBlockComment := false
+
+	for lineNo, line := range strings.Split(string(content), "\n") {
+		if inBlockComment {
+			if idx := strings.Index(line, "--;"); idx != -1 {
+				inBlockComment = false
+				line = line[idx+3:]
+			} else {
+				continue
+			}
+		}
+		if idx := strings.Index(line, ";--"); idx != -1 {
+			inBlockComment = !strings.Contains(line[idx:], "--;")
+			line = line[:idx]
+		}
+
+		line = stripComment(line)
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "#include") {
+			include := strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, "#include")), `"<>`)
+			if !filepath.IsAbs(include) {
+				include = filepath.Join(filepath.Dir(file), include)
+			}
+
+			err := readPjsipFile(include, sections, order, depth+1)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if strings.HasPrefix(line, "#") {
+			// #exec and friends are not supported
+			continue
+		}
+
+		if m := sectionRx.FindStringSubmatch(line); m != nil {
+			name := strings.TrimSpace(m[1])
+			isTemplate := false
+			templates := make([]string, 0)
+			appendToExisting := false
+
+			for _, flag := range strings.Split(m[3], ",") {
+				flag = strings.TrimSpace(flag)
+				switch flag {
+				case "":
+				case "!":
+					isTemplate = true
+				case "+":
+					appendToExisting = true
+				default:
+					templates = append(templates, flag)
+				}
+			}
+
+			// [name](+) continues the most recent section of that name
+			if existing := sections[name]; len(existing) > 0 && appendToExisting {
+				current = existing[len(existing)-1]
+				continue
+			}
+
+			current = &pjsipSection{Name: name, IsTemplate: isTemplate, Templates: templates, Options: make([]pjsipOption, 0)}
+			sections[name] = append(sections[name], current)
+			*order = append(*order, current)
+			continue
+		}
+
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			return fmt.Errorf("%v: line #%v '%v' has invalid format", file, lineNo+1, line)
+		}
+		if current == nil {
+			return fmt.Errorf("%v: line #%v '%v' is outside of a section", file, lineNo+1, line)
+		}
+
+		value = strings.TrimPrefix(value, ">")
+		current.Options = append(current.Options, pjsipOption{Key: strings.TrimSpace(key), Value: strings.TrimSpace(value)})
+	}
+
+	return nil
+}
+
+func loadPjsip(file string) (map[string]Credentials, []string, error) {
+	sections := make(pjsipSections)
+	order := make([]*pjsipSection, 0)
+
+	err := readPjsipFile(file, sections, &order, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	res := make(map[string]Credentials)
+	warnings := make([]string, 0)
+	for _, endpoint := range order {
+		if endpoint.IsTemplate || endpoint.get(sections, "type") != "endpoint" {
+			continue
+		}
+
+		cred := Credentials{Endpoint: endpoint.Name}
+
+		if m := callerIDRx.FindStringSubmatch(endpoint.get(sections, "callerid")); m != nil {
+			cred.Extension = strings.TrimSpace(m[1])
+		}
+
+		authName := strings.TrimSpace(strings.Split(endpoint.get(sections, "auth"), ",")[0])
+		if authName != "" {
+			auth := sections.find(authName, "auth")
+			if auth == nil {
+				return nil, nil, fmt.Errorf("endpoint %v references unknown auth section '%v'", endpoint.Name, authName)
+			}
+
+			// md5 and friends don't contain a password the phone could use
+			authType := auth.get(sections, "auth_type")
+			if authType != "" && authType != "userpass" {
+				warnings = append(warnings, fmt.Sprintf("skipped endpoint %v: auth section %v uses unsupported auth_type '%v'", endpoint.Name, authName, authType))
+				continue
+			}
+
+			cred.Username = auth.get(sections, "username")
+			cred.Password = auth.get(sections, "password")
+		}
+
+		res[cred.Endpoint] = cred.withDefaults()
+	}
+
+	return res, warnings, nil
+}
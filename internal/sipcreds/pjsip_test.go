@@ -0,0 +1,236 @@
+package sipcreds
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFiles writes the files (by name) to a temporary directory and returns its path
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, content := range files {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func loadPjsipConf(t *testing.T, files map[string]string) (map[string]Credentials, []string) {
+	t.Helper()
+
+	dir := writeFiles(t, files)
+	creds, warnings, err := Load("pjsip:" + filepath.Join(dir, "pjsip.conf"))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	return creds, warnings
+}
+
+func TestLoadPjsip(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    map[string]string
+		want     map[string]Credentials
+		warnings int
+	}{
+		{
+			name: "same name for endpoint, auth and aor",
+			files: map[string]string{"pjsip.conf": `
+[4242]
+type=endpoint
+auth=4242
+aors=4242
+callerid=Reception <100>
+
+[4242]
+type=auth
+auth_type=userpass
+username=u4242
+password=secret ; comment
+
+[4242]
+type=aor
+max_contacts=1
+`},
+			want: map[string]Credentials{"4242": {Endpoint: "4242", Username: "u4242", Password: "secret", Extension: "100"}},
+		},
+		{
+			name: "templates",
+			files: map[string]string{"pjsip.conf": `
+[endpoint-base](!)
+type=endpoint
+context=phones
+
+[auth-base](!)
+type=auth
+auth_type=userpass
+password=default
+
+[phone-base](!,endpoint-base)
+callerid=Phone <200>
+
+[4242](phone-base)
+auth=auth4242
+
+[auth4242](auth-base)
+username=u4242
+
+[4343](phone-base)
+auth=auth4343
+callerid=Other <4343>
+
+[auth4343](auth-base)
+password=other
+`},
+			want: map[string]Credentials{
+				"4242": {Endpoint: "4242", Username: "u4242", Password: "default", Extension: "200"},
+				"4343": {Endpoint: "4343", Username: "4343", Password: "other", Extension: "4343"},
+			},
+		},
+		{
+			name: "appended sections",
+			files: map[string]string{"pjsip.conf": `
+[4242]
+type=endpoint
+auth=4242
+
+[4242]
+type=auth
+username=u4242
+password=old
+
+[4242](+)
+password=new
+`},
+			want: map[string]Credentials{"4242": {Endpoint: "4242", Username: "u4242", Password: "new", Extension: "4242"}},
+		},
+		{
+			name: "includes",
+			files: map[string]string{
+				"pjsip.conf": `
+[global]
+type=global
+
+#include "endpoints.conf"
+#include <auth.conf>
+`,
+				"endpoints.conf": `
+[4242]
+type=endpoint
+auth=auth4242
+`,
+				"auth.conf": `
+;-- block comment
+[ignored]
+type=endpoint
+--;
+[auth4242]
+type=auth
+password=secret
+`,
+			},
+			want: map[string]Credentials{"4242": {Endpoint: "4242", Username: "4242", Password: "secret", Extension: "4242"}},
+		},
+		{
+			name: "endpoint without auth",
+			files: map[string]string{"pjsip.conf": `
+[trunk]
+type=endpoint
+`},
+			want: map[string]Credentials{"trunk": {Endpoint: "trunk", Username: "trunk", Extension: "trunk"}},
+		},
+		{
+			name: "unsupported auth type",
+			files: map[string]string{"pjsip.conf": `
+[4242]
+type=endpoint
+auth=4242
+
+[4242]
+type=auth
+auth_type=md5
+md5_cred=0123456789abcdef
+
+[4343]
+type=endpoint
+auth=4343
+
+[4343]
+type=auth
+password=secret
+`},
+			want:     map[string]Credentials{"4343": {Endpoint: "4343", Username: "4343", Password: "secret", Extension: "4343"}},
+			warnings: 1,
+		},
+		{
+			name: "templates referencing each other",
+			files: map[string]string{"pjsip.conf": `
+[a](!)
+type=endpoint
+
+[b](!,a)
+context=phones
+
+[a](!,b)
+type=endpoint
+
+[4242](a)
+auth=4242
+
+[4242]
+type=auth
+password=secret
+`},
+			want: map[string]Credentials{"4242": {Endpoint: "4242", Username: "4242", Password: "secret", Extension: "4242"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			creds, warnings := loadPjsipConf(t, tt.files)
+
+			if len(creds) != len(tt.want) {
+				t.Errorf("Load returned %v endpoints, want %v: %+v", len(creds), len(tt.want), creds)
+			}
+			for name, want := range tt.want {
+				if got := creds[name]; got != want {
+					t.Errorf("endpoint %v = %+v, want %+v", name, got, want)
+				}
+			}
+			if len(warnings) != tt.warnings {
+				t.Errorf("Load returned warnings %v, want %v", warnings, tt.warnings)
+			}
+		})
+	}
+}
+
+func TestLoadPjsipInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		err   string
+	}{
+		{"unknown auth", map[string]string{"pjsip.conf": "[4242]\ntype=endpoint\nauth=missing\n"}, "unknown auth section"},
+		{"auth of wrong type", map[string]string{"pjsip.conf": "[4242]\ntype=endpoint\nauth=4242\n"}, "unknown auth section"},
+		{"option outside of section", map[string]string{"pjsip.conf": "type=endpoint\n"}, "outside of a section"},
+		{"invalid line", map[string]string{"pjsip.conf": "[4242]\ntype\n"}, "invalid format"},
+		{"missing include", map[string]string{"pjsip.conf": "#include missing.conf\n"}, "unable to read"},
+		{"recursive include", map[string]string{"pjsip.conf": "#include pjsip.conf\n"}, "nested includes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeFiles(t, tt.files)
+			_, _, err := Load("pjsip:" + filepath.Join(dir, "pjsip.conf"))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Load error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
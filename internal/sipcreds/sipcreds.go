@@ -0,0 +1,70 @@
+package sipcreds
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Credentials of a single SIP endpoint as known by the registrar
+type Credentials struct {
+	Endpoint  string `json:"endpoint"`
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+	Extension string `json:"extension"`
+}
+
+// Load reads all endpoint credentials from source, which is either
+// pjsip:<file> for an Asterisk pjsip.conf or json:<file> for a JSON export.
+// The result is indexed by endpoint name. Endpoints which can't be used are
+// skipped; the returned warnings say why.
+func Load(source string) (map[string]Credentials, []string, error) {
+	kind, file, found := strings.Cut(source, ":")
+	if !found {
+		return nil, nil, fmt.Errorf("invalid credential source '%v', expected pjsip:<file> or json:<file>", source)
+	}
+
+	switch kind {
+	case "pjsip":
+		return loadPjsip(file)
+	case "json":
+		creds, err := loadJSON(file)
+		return creds, []string{}, err
+	default:
+		return nil, nil, fmt.Errorf("unknown credential source type '%v'", kind)
+	}
+}
+
+func loadJSON(file string) (map[string]Credentials, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read file %v: %v", file, err)
+	}
+
+	var list []Credentials
+	err = json.Unmarshal(content, &list)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %v: %v", file, err)
+	}
+
+	res := make(map[string]Credentials)
+	for _, cred := range list {
+		if cred.Endpoint == "" {
+			return nil, fmt.Errorf("%v contains credentials without endpoint name", file)
+		}
+		res[cred.Endpoint] = cred.withDefaults()
+	}
+
+	return res, nil
+}
+
+func (cred Credentials) withDefaults() Credentials {
+	if cred.Username == "" {
+		cred.Username = cred.Endpoint
+	}
+	if cred.Extension == "" {
+		cred.Extension = cred.Endpoint
+	}
+	return cred
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zam-haus/dlsir/internal/firmware"
+)
+
+const inventoryFile = stateDir + "/inventory.json"
+
+type updateResult string
+
+const (
+	UpdatePending    updateResult = "pending"
+	UpdateSucceeded  updateResult = "succeeded"
+	UpdateFailed     updateResult = "failed"
+	UpdateRolledBack updateResult = "rolled-back"
+)
+
+// updateAttempt records a single software deployment
+type updateAttempt struct {
+	From     string       `json:"from"`
+	To       string       `json:"to"`
+	Started  time.Time    `json:"started"`
+	Finished time.Time    `json:"finished"`
+	Result   updateResult `json:"result"`
+	Note     string       `json:"note,omitempty"`
+}
+
+// Number of file deployment results kept per phone
+const maxFileHistory = 100
+
+// fileAttempt records the result of a single file deployment
+type fileAttempt struct {
+	Name    string     `json:"name"`
+	Time    time.Time  `json:"time"`
+	Attempt int        `json:"attempt"`
+	Status  fileStatus `json:"status"`
+	Detail  string     `json:"detail,omitempty"`
+}
+
+// inventoryPhone is everything DLSir knows about a phone, keyed by MAC address
+type inventoryPhone struct {
+	Mac       string          `json:"mac"`
+	Number    string          `json:"number"`
+	IP        string          `json:"ip"`
+	DevType   string          `json:"device-type"`
+	FwType    string          `json:"software-type"`
+	FwVersion string          `json:"software-version"`
+	LastSeen  time.Time       `json:"last-seen"`
+	Updates   []updateAttempt `json:"updates"`
+	Files     []fileAttempt   `json:"files"`
+	// ContactMe schedule of managed phones
+	NextContact     time.Time `json:"next-contact"`
+	ContactFailures int       `json:"contact-failures"`
+}
+
+type inventoryStore struct {
+	mu     sync.Mutex
+	file   string
+	Phones map[string]*inventoryPhone
+}
+
+var inventory = &inventoryStore{file: inventoryFile, Phones: make(map[string]*inventoryPhone)}
+
+func (store *inventoryStore) load() error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	content, err := os.ReadFile(store.file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read file %v: %v", store.file, err)
+	}
+
+	err = json.Unmarshal(content, &store.Phones)
+	if err != nil {
+		return fmt.Errorf("failed to parse %v: %v", store.file, err)
+	}
+
+	return nil
+}
+
+// save must be called with store.mu held
+func (store *inventoryStore) save() {
+	err := writeJSONFile(store.file, store.Phones)
+	if err != nil {
+		_log(nil, "Failed to save inventory: %v", err)
+	}
+}
+
+// update calls fn with the inventory entry of the phone (creating it if required) and saves the inventory
+func (store *inventoryStore) update(mac string, fn func(p *inventoryPhone)) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	p, ok := store.Phones[mac]
+	if !ok {
+		p = &inventoryPhone{Mac: mac, Updates: make([]updateAttempt, 0), Files: make([]fileAttempt, 0)}
+		store.Phones[mac] = p
+	}
+
+	fn(p)
+	store.save()
+}
+
+// lastPending returns the pending update attempt of a phone (or nil)
+func (p *inventoryPhone) lastPending() *updateAttempt {
+	if len(p.Updates) == 0 || p.Updates[len(p.Updates)-1].Result != UpdatePending {
+		return nil
+	}
+	return &p.Updates[len(p.Updates)-1]
+}
+
+// finish evaluates the pending update attempt based on the version the phone now reports
+func (attempt *updateAttempt) finish(version firmware.FirmwareVersion) {
+	attempt.Finished = time.Now()
+
+	switch version.String() {
+	case attempt.To:
+		attempt.Result = UpdateSucceeded
+	case attempt.From:
+		attempt.Result = UpdateRolledBack
+		attempt.Note = "phone still runs the previous version"
+	default:
+		attempt.Result = UpdateFailed
+		attempt.Note = "phone runs unexpected version " + version.String()
+	}
+}
+
+// Seen records the current state of a phone on initial contact.
+// A pending update attempt (e.g. from before a server restart) is evaluated with the reported version.
+func (store *inventoryStore) Seen(phone *phoneDesc) *updateAttempt {
+	var finished *updateAttempt
+
+	store.update(phone.Mac, func(p *inventoryPhone) {
+		p.Number = phone.Number
+		p.IP = phone.IP
+		p.DevType = phone.DevType
+		p.FwType = phone.FwType
+		p.FwVersion = phone.FwVersion.String()
+		p.LastSeen = time.Now()
+
+		if attempt := p.lastPending(); attempt != nil {
+			attempt.finish(phone.FwVersion)
+			cp := *attempt
+			finished = &cp
+		}
+	})
+
+	return finished
+}
+
+// UpdateStarted records a new update attempt from the phone's current version to target
+func (store *inventoryStore) UpdateStarted(phone *phoneDesc, target firmware.FirmwareVersion) {
+	store.update(phone.Mac, func(p *inventoryPhone) {
+		if attempt := p.lastPending(); attempt != nil {
+			attempt.Finished = time.Now()
+			attempt.Result = UpdateFailed
+			attempt.Note = "superseded by a new attempt"
+		}
+
+		p.Updates = append(p.Updates, updateAttempt{From: phone.FwVersion.String(), To: target.String(), Started: time.Now(), Result: UpdatePending})
+	})
+}
+
+// UpdateFinished evaluates the pending update attempt of a phone which came back after an update.
+// version is nil if the phone didn't report its software version.
+func (store *inventoryStore) UpdateFinished(phone *phoneDesc, version *firmware.FirmwareVersion, note string) updateAttempt {
+	var res updateAttempt
+
+	store.update(phone.Mac, func(p *inventoryPhone) {
+		attempt := p.lastPending()
+		if attempt == nil {
+			p.Updates = append(p.Updates, updateAttempt{From: phone.FwVersion.String(), To: phone.FwTarget.FwVersion.String(), Result: UpdatePending})
+			attempt = &p.Updates[len(p.Updates)-1]
+		}
+
+		if version == nil {
+			attempt.Finished = time.Now()
+			attempt.Result = UpdateFailed
+			attempt.Note = note
+		} else {
+			attempt.finish(*version)
+			p.FwVersion = version.String()
+		}
+		p.LastSeen = time.Now()
+
+		res = *attempt
+	})
+
+	return res
+}
+
+// FilesDeployed records the results of a FileDeployment; attempt counts the retries
+func (store *inventoryStore) FilesDeployed(phone *phoneDesc, results []fileResult, attempt int) {
+	store.update(phone.Mac, func(p *inventoryPhone) {
+		for _, result := range results {
+			p.Files = append(p.Files, fileAttempt{Name: result.Name, Time: time.Now(), Attempt: attempt, Status: result.Status, Detail: result.Detail})
+		}
+		if len(p.Files) > maxFileHistory {
+			p.Files = slices.Clone(p.Files[len(p.Files)-maxFileHistory:])
+		}
+	})
+}
+
+// ContactScheduled records the next ContactMe of a managed phone and its consecutive failed contacts
+func (store *inventoryStore) ContactScheduled(mac string, next time.Time, failures int) {
+	store.update(mac, func(p *inventoryPhone) {
+		p.NextContact = next
+		p.ContactFailures = failures
+	})
+}
+
+// NextContact returns the recorded next ContactMe of a phone (zero if there is none) and its consecutive failed contacts
+func (store *inventoryStore) NextContact(mac string) (time.Time, int) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	p, ok := store.Phones[mac]
+	if !ok {
+		return time.Time{}, 0
+	}
+	return p.NextContact, p.ContactFailures
+}
+
+// MacsByIP maps the IP addresses phones were last seen at to their MAC address. If several
+// phones were seen at the same address, the one seen last wins.
+func (store *inventoryStore) MacsByIP() map[string]string {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	res := make(map[string]string)
+	for mac, p := range store.Phones {
+		if p.IP == "" {
+			continue
+		}
+		if other, ok := res[p.IP]; ok && store.Phones[other].LastSeen.After(p.LastSeen) {
+			continue
+		}
+		res[p.IP] = mac
+	}
+	return res
+}
+
+// DeviceType returns the device type a phone reported last (or "")
+func (store *inventoryStore) DeviceType(mac string) string {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if p, ok := store.Phones[mac]; ok {
+		return p.DevType
+	}
+	return ""
+}
+
+// List returns a copy of all phones ordered by number
+func (store *inventoryStore) List() []inventoryPhone {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	res := make([]inventoryPhone, 0)
+	for _, p := range store.Phones {
+		cp := *p
+		cp.Updates = slices.Clone(p.Updates)
+		cp.Files = slices.Clone(p.Files)
+		res = append(res, cp)
+	}
+	slices.SortFunc(res, func(a, b inventoryPhone) int { return strings.Compare(a.Number, b.Number) })
+
+	return res
+}
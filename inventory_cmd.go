@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
+
+func printInventory(list []inventoryPhone, withUpdates bool, withFiles bool) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "MAC\tNUMBER\tIP\tDEVICE\tSOFTWARE\tLAST SEEN\tNEXT CONTACT\tLAST UPDATE")
+
+	for _, p := range list {
+		lastUpdate := "-"
+		if len(p.Updates) > 0 {
+			u := p.Updates[len(p.Updates)-1]
+			lastUpdate = fmt.Sprintf("%v -> %v %v (%v)", u.From, u.To, u.Result, formatTime(u.Started))
+		}
+
+		nextContact := formatTime(p.NextContact)
+		if p.ContactFailures > 0 {
+			nextContact = fmt.Sprintf("%v (%v failed)", nextContact, p.ContactFailures)
+		}
+
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v %v\t%v\t%v\t%v\n", p.Mac, p.Number, p.IP, p.DevType, p.FwType, p.FwVersion, formatTime(p.LastSeen), nextContact, lastUpdate)
+
+		if withUpdates {
+			for _, u := range p.Updates {
+				fmt.Fprintf(w, "\t\t\t\t%v -> %v\t%v - %v\t\t%v %v\n", u.From, u.To, formatTime(u.Started), formatTime(u.Finished), u.Result, u.Note)
+			}
+		}
+
+		if withFiles {
+			for _, f := range p.Files {
+				status := string(f.Status)
+				if f.Detail != "" && !strings.EqualFold(f.Detail, status) {
+					status = fmt.Sprintf("%v (%v)", status, f.Detail)
+				}
+				fmt.Fprintf(w, "\t\t\t\t%v\t%v\t\tattempt %v\t%v\n", f.Name, formatTime(f.Time), f.Attempt, status)
+			}
+		}
+	}
+
+	w.Flush()
+}
+
+func runInventory(args []string) int {
+	flags := flag.NewFlagSet("inventory", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the inventory as JSON")
+	withUpdates := flags.Bool("updates", false, "list all software update attempts")
+	withFiles := flags.Bool("files", false, "list the results of the recent file deployments")
+	_ = flags.Parse(args)
+
+	api, err := newAPIClient()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	var list []inventoryPhone
+	err = api.do(http.MethodGet, "/inventory", nil, &list)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to get inventory: %v\n", err)
+		return 1
+	}
+
+	if *asJSON {
+		out, _ := json.MarshalIndent(list, "", "  ")
+		fmt.Println(string(out))
+	} else {
+		printInventory(list, *withUpdates, *withFiles)
+	}
+
+	return 0
+}
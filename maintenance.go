@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zam-haus/dlsir/internal/config"
+)
+
+// Per-phone maintenance window (phone config)
+const phoneMaintenanceEntry = config.LocalPrefix + "maintenance-window"
+
+// maintenanceWindow is a daily time range in which software deployments may happen
+type maintenanceWindow struct {
+	Text string
+	// minutes since midnight; End < Start spans midnight
+	Start int
+	End   int
+	Loc   *time.Location
+}
+
+func parseTimeOfDay(text string) (int, error) {
+	var hour, minute int
+	_, err := fmt.Sscanf(text, "%d:%d", &hour, &minute)
+	if err != nil || hour < 0 || hour > 24 || minute < 0 || minute > 59 || (hour == 24 && minute != 0) {
+		return 0, fmt.Errorf("invalid time of day '%v'", text)
+	}
+	return hour*60 + minute, nil
+}
+
+// parseMaintenanceWindow parses windows like "22:00-05:00 Europe/Berlin"; the time zone defaults to local time
+func parseMaintenanceWindow(text string) (*maintenanceWindow, error) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, fmt.Errorf("invalid maintenance window '%v', expected HH:MM-HH:MM [time zone]", text)
+	}
+
+	startText, endText, found := strings.Cut(fields[0], "-")
+	if !found {
+		return nil, fmt.Errorf("invalid maintenance window '%v', expected HH:MM-HH:MM [time zone]", text)
+	}
+
+	start, err := parseTimeOfDay(startText)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseTimeOfDay(endText)
+	if err != nil {
+		return nil, err
+	}
+	// 00:00-24:00 is the whole day, 22:00-22:00 or 24:00-00:00 would never open
+	if start == end || start > end && start%(24*60) == end%(24*60) {
+		return nil, fmt.Errorf("maintenance window '%v' is empty; use 'always' to allow deployments at any time", text)
+	}
+
+	loc := time.Local
+	if len(fields) == 2 {
+		loc, err = time.LoadLocation(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("unknown time zone '%v': %v", fields[1], err)
+		}
+	}
+
+	return &maintenanceWindow{Text: text, Start: start, End: end, Loc: loc}, nil
+}
+
+func (window maintenanceWindow) String() string {
+	return window.Text
+}
+
+// Contains returns whether t is within the window
+func (window maintenanceWindow) Contains(t time.Time) bool {
+	t = t.In(window.Loc)
+	minute := t.Hour()*60 + t.Minute()
+
+	if window.Start <= window.End {
+		return minute >= window.Start && minute < window.End
+	}
+	return minute >= window.Start || minute < window.End
+}
+
+// NextOpen returns the next time the window opens after t (or t itself if it is open)
+func (window maintenanceWindow) NextOpen(t time.Time) time.Time {
+	if window.Contains(t) {
+		return t
+	}
+
+	t = t.In(window.Loc)
+	open := time.Date(t.Year(), t.Month(), t.Day(), window.Start/60, window.Start%60, 0, 0, window.Loc)
+	if !open.After(t) {
+		open = open.AddDate(0, 0, 1)
+	}
+	return open
+}
+
+// getMaintenanceWindow returns the window of a phone from dlsir-maintenance-window in its config,
+// maintenance-window-<group> or maintenance-window in the server config (in this order).
+// Without any of them, software may be deployed at any time (nil is returned).
+func getMaintenanceWindow(srvConf *config.ConfigFile, phoneConf *config.ConfigFile) (*maintenanceWindow, error) {
+	lookups := []configLookup{{phoneConf, phoneMaintenanceEntry}}
+	for _, group := range phoneConf.GetGroups() {
+		lookups = append(lookups, configLookup{srvConf, "maintenance-window-" + group})
+	}
+	lookups = append(lookups, configLookup{srvConf, "maintenance-window"})
+
+	entry, ok := firstEntry(lookups)
+	if !ok || entry.Value == "always" {
+		return nil, nil
+	}
+
+	window, err := parseMaintenanceWindow(entry.Value)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", entry.Name, err)
+	}
+	return window, nil
+}
+
+var deferredContacts = struct {
+	sync.Mutex
+	timers map[string]*time.Timer
+}{timers: make(map[string]*time.Timer)}
+
+// scheduleContactMe sends a ContactMe to host at the given time; only one ContactMe is scheduled per host
+func scheduleContactMe(host string, at time.Time) {
+	deferredContacts.Lock()
+	defer deferredContacts.Unlock()
+
+	if _, ok := deferredContacts.timers[host]; ok {
+		return
+	}
+
+	deferredContacts.timers[host] = time.AfterFunc(time.Until(at), func() {
+		deferredContacts.Lock()
+		delete(deferredContacts.timers, host)
+		deferredContacts.Unlock()
+
+		_log(nil, "Maintenance window opened; contacting %v for deferred software deployment", host)
+		sendContactMe(listenPort, host)
+	})
+}
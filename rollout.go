@@ -0,0 +1,355 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/zam-haus/dlsir/internal/config"
+	"github.com/zam-haus/dlsir/internal/firmware"
+)
+
+const rolloutFile = stateDir + "/rollouts.json"
+
+type rolloutState string
+
+const (
+	RolloutActive rolloutState = "active"
+	RolloutPaused rolloutState = "paused"
+)
+
+// rolloutPhone tracks a phone admitted to a rollout
+type rolloutPhone struct {
+	Number   string       `json:"number"`
+	Stage    int          `json:"stage"`
+	Admitted time.Time    `json:"admitted"`
+	Started  time.Time    `json:"started"`
+	Returned time.Time    `json:"returned"`
+	TimedOut bool         `json:"timed-out,omitempty"`
+	Result   updateResult `json:"result,omitempty"`
+}
+
+// rollout restricts the deployment of a firmware version to a growing set of phones.
+// Stage 0 only admits the canary group; every further stage admits another batch of the fleet.
+type rollout struct {
+	Name    string `json:"name"`
+	DevType string `json:"device-type"`
+	// empty matches both SIP and HFA
+	FwType       string       `json:"software-type,omitempty"`
+	Version      string       `json:"version"`
+	CanaryGroup  string       `json:"canary-group"`
+	BatchSize    int          `json:"batch-size,omitempty"`
+	BatchPercent int          `json:"batch-percent,omitempty"`
+	Timeout      jsonDuration `json:"timeout"`
+
+	Stage       int                      `json:"stage"`
+	State       rolloutState             `json:"state"`
+	PauseReason string                   `json:"pause-reason,omitempty"`
+	Created     time.Time                `json:"created"`
+	Phones      map[string]*rolloutPhone `json:"phones"`
+}
+
+type rolloutStore struct {
+	mu       sync.Mutex
+	file     string
+	Rollouts map[string]*rollout
+}
+
+var rollouts = &rolloutStore{file: rolloutFile, Rollouts: make(map[string]*rollout)}
+
+func (store *rolloutStore) load() error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	content, err := os.ReadFile(store.file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read file %v: %v", store.file, err)
+	}
+
+	err = json.Unmarshal(content, &store.Rollouts)
+	if err != nil {
+		return fmt.Errorf("failed to parse %v: %v", store.file, err)
+	}
+
+	return nil
+}
+
+// save must be called with store.mu held
+func (store *rolloutStore) save() {
+	err := writeJSONFile(store.file, store.Rollouts)
+	if err != nil {
+		_log(nil, "Failed to save rollouts: %v", err)
+	}
+}
+
+func (r *rollout) validate() error {
+	if r.Name == "" || r.DevType == "" || r.CanaryGroup == "" {
+		return fmt.Errorf("name, device-type and canary-group are required")
+	}
+
+	ver, err := firmware.ParseFirmwareVersion(r.Version)
+	if err != nil {
+		return fmt.Errorf("invalid version '%v': %v", r.Version, err)
+	}
+	r.Version = ver.String()
+
+	if (r.BatchSize > 0) == (r.BatchPercent > 0) {
+		return fmt.Errorf("exactly one of batch-size and batch-percent is required")
+	}
+	if r.BatchPercent > 100 {
+		return fmt.Errorf("batch-percent must not exceed 100")
+	}
+	if time.Duration(r.Timeout) <= 0 {
+		return fmt.Errorf("timeout is required")
+	}
+
+	return nil
+}
+
+func (r *rollout) matches(devType string, fwType string, target firmware.FirmwareVersion) bool {
+	return firmware.DeviceKey(r.DevType) == firmware.DeviceKey(devType) &&
+		(r.FwType == "" || r.FwType == fwType) &&
+		r.Version == target.String()
+}
+
+// stageLimit returns the number of non-canary phones admitted up to the current stage
+func (r *rollout) stageLimit(fleetSize int) int {
+	if r.BatchSize > 0 {
+		return r.Stage * r.BatchSize
+	}
+	return int(math.Ceil(float64(r.Stage*r.BatchPercent*fleetSize) / 100))
+}
+
+func (r *rollout) pause(reason string) {
+	r.State = RolloutPaused
+	r.PauseReason = reason
+	_log(nil, "Rollout %v paused: %v", r.Name, reason)
+}
+
+// checkTimeouts pauses the rollout if an updated phone didn't come back in time
+func (r *rollout) checkTimeouts(now time.Time) bool {
+	changed := false
+
+	for mac, phone := range r.Phones {
+		if phone.Started.IsZero() || !phone.Returned.IsZero() || phone.TimedOut {
+			continue
+		}
+
+		if now.Sub(phone.Started) > time.Duration(r.Timeout) {
+			phone.TimedOut = true
+			changed = true
+			r.pause(fmt.Sprintf("phone %v (%v) did not come back within %v after the update", phone.Number, mac, r.Timeout))
+		}
+	}
+
+	return changed
+}
+
+// Admit returns whether the phone may be updated to target and, if so, records it in the rollout.
+// Phones without a matching rollout are always admitted.
+func (store *rolloutStore) Admit(phone *phoneDesc, groups []string, target firmware.FirmwareVersion, fleetSize int) (bool, string) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for _, r := range store.Rollouts {
+		if !r.matches(phone.DevType, phone.FwType, target) {
+			continue
+		}
+
+		if r.State == RolloutPaused {
+			return false, fmt.Sprintf("rollout %v is paused: %v", r.Name, r.PauseReason)
+		}
+
+		if rp, ok := r.Phones[phone.Mac]; ok {
+			return true, fmt.Sprintf("already admitted to rollout %v in stage %v", r.Name, rp.Stage)
+		}
+
+		isCanary := slices.Contains(groups, r.CanaryGroup)
+		if !isCanary {
+			admitted := 0
+			for _, rp := range r.Phones {
+				if rp.Stage > 0 {
+					admitted++
+				}
+			}
+
+			if admitted >= r.stageLimit(fleetSize) {
+				return false, fmt.Sprintf("rollout %v in stage %v has no capacity left", r.Name, r.Stage)
+			}
+		}
+
+		stage := r.Stage
+		if isCanary {
+			stage = 0
+		}
+		r.Phones[phone.Mac] = &rolloutPhone{Number: phone.Number, Stage: stage, Admitted: time.Now()}
+		store.save()
+
+		return true, fmt.Sprintf("admitted to rollout %v in stage %v", r.Name, stage)
+	}
+
+	return true, "no rollout restrictions"
+}
+
+// UpdateStarted records that the software deployment was sent to the phone
+func (store *rolloutStore) UpdateStarted(phone *phoneDesc) {
+	store.update(phone.Mac, func(r *rollout, rp *rolloutPhone) {
+		rp.Started = time.Now()
+		rp.Returned = time.Time{}
+		rp.TimedOut = false
+		rp.Result = UpdatePending
+	})
+}
+
+// PhoneReturned records that the phone came back after the software deployment.
+// The rollout is paused if the update did not succeed.
+func (store *rolloutStore) PhoneReturned(phone *phoneDesc, attempt updateAttempt) {
+	store.update(phone.Mac, func(r *rollout, rp *rolloutPhone) {
+		if rp.Started.IsZero() || !rp.Returned.IsZero() {
+			return
+		}
+
+		rp.Returned = time.Now()
+		rp.Result = attempt.Result
+		if attempt.Result != UpdateSucceeded {
+			r.pause(fmt.Sprintf("update of phone %v (%v) %v: %v", phone.Number, phone.Mac, attempt.Result, attempt.Note))
+		}
+	})
+}
+
+func (store *rolloutStore) update(mac string, fn func(r *rollout, rp *rolloutPhone)) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	changed := false
+	for _, r := range store.Rollouts {
+		if rp, ok := r.Phones[mac]; ok {
+			fn(r, rp)
+			changed = true
+		}
+	}
+
+	if changed {
+		store.save()
+	}
+}
+
+// CheckTimeouts pauses all rollouts with phones which didn't come back in time
+func (store *rolloutStore) CheckTimeouts() {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	changed := false
+	for _, r := range store.Rollouts {
+		if r.checkTimeouts(time.Now()) {
+			changed = true
+		}
+	}
+
+	if changed {
+		store.save()
+	}
+}
+
+// List returns a copy of all rollouts ordered by creation time
+func (store *rolloutStore) List() []rollout {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	res := make([]rollout, 0)
+	for _, r := range store.Rollouts {
+		cp := *r
+		cp.Phones = make(map[string]*rolloutPhone)
+		for mac, rp := range r.Phones {
+			rpCopy := *rp
+			cp.Phones[mac] = &rpCopy
+		}
+		res = append(res, cp)
+	}
+	slices.SortFunc(res, func(a, b rollout) int { return a.Created.Compare(b.Created) })
+
+	return res
+}
+
+func (store *rolloutStore) Create(r rollout) error {
+	err := r.validate()
+	if err != nil {
+		return err
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, ok := store.Rollouts[r.Name]; ok {
+		return fmt.Errorf("rollout %v already exists", r.Name)
+	}
+
+	r.Stage = 0
+	r.State = RolloutActive
+	r.PauseReason = ""
+	r.Created = time.Now()
+	r.Phones = make(map[string]*rolloutPhone)
+	store.Rollouts[r.Name] = &r
+	store.save()
+
+	_log(nil, "Rollout %v created for %v %v", r.Name, r.DevType, r.Version)
+
+	return nil
+}
+
+// Modify applies action (advance, pause, resume or delete) to the named rollout
+func (store *rolloutStore) Modify(name string, action string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	r, ok := store.Rollouts[name]
+	if !ok {
+		return fmt.Errorf("no such rollout %v", name)
+	}
+
+	switch action {
+	case "advance":
+		if r.State == RolloutPaused {
+			return fmt.Errorf("rollout %v is paused; resume it first", name)
+		}
+		r.Stage++
+	case "pause":
+		r.pause("paused manually")
+	case "resume":
+		r.State = RolloutActive
+		r.PauseReason = ""
+	case "delete":
+		delete(store.Rollouts, name)
+	default:
+		return fmt.Errorf("unknown action %v", action)
+	}
+
+	store.save()
+	_log(nil, "Rollout %v: %v (stage %v)", name, action, r.Stage)
+
+	return nil
+}
+
+// rolloutTimerFunc regularly checks all rollouts for phones which didn't come back in time
+func rolloutTimerFunc() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		rollouts.CheckTimeouts()
+	}
+}
+
+// fleetSize returns the number of managed phones
+func fleetSize(srvConf *config.ConfigFile) int {
+	return len(srvConf.GetFilteredEntries("managed-phones", true))
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+func printRollouts(list []rollout) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tDEVICE\tVERSION\tCANARY\tBATCH\tSTAGE\tSTATE\tPHONES (updated/returned/timed out)")
+
+	for _, r := range list {
+		batch := fmt.Sprintf("%v", r.BatchSize)
+		if r.BatchPercent > 0 {
+			batch = fmt.Sprintf("%v%%", r.BatchPercent)
+		}
+
+		started, returned, timedOut := 0, 0, 0
+		for _, rp := range r.Phones {
+			if !rp.Started.IsZero() {
+				started++
+			}
+			if !rp.Returned.IsZero() {
+				returned++
+			}
+			if rp.TimedOut {
+				timedOut++
+			}
+		}
+
+		state := string(r.State)
+		if r.PauseReason != "" {
+			state += " (" + r.PauseReason + ")"
+		}
+
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v/%v/%v\n", r.Name, r.DevType, r.Version, r.CanaryGroup, batch, r.Stage, state, started, returned, timedOut)
+	}
+
+	w.Flush()
+}
+
+func rolloutUsage() int {
+	fmt.Fprintf(os.Stderr, "Usage: %v rollout <list|create|advance|pause|resume|delete> ...\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  rollout list\n")
+	fmt.Fprintf(os.Stderr, "  rollout create -device <type> -version <version> -canary <group> (-batch <n>|-percent <p>) -timeout <duration> <name>\n")
+	fmt.Fprintf(os.Stderr, "  rollout advance|pause|resume|delete <name>\n")
+	return 2
+}
+
+func runRollout(args []string) int {
+	if len(args) == 0 {
+		return rolloutUsage()
+	}
+
+	api, err := newAPIClient()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	switch args[0] {
+	case "list":
+		var list []rollout
+		err = api.do(http.MethodGet, "/rollouts", nil, &list)
+		if err == nil {
+			printRollouts(list)
+		}
+
+	case "create":
+		flags := flag.NewFlagSet("rollout create", flag.ExitOnError)
+		r := rollout{}
+		flags.StringVar(&r.DevType, "device", "", "device type, e.g. 'OpenStage 40'")
+		flags.StringVar(&r.FwType, "software-type", "", "only for 'Siemens SIP' or 'Siemens HFA' phones")
+		flags.StringVar(&r.Version, "version", "", "firmware version, e.g. 'V3 R5.1.0'")
+		flags.StringVar(&r.CanaryGroup, "canary", "", "group updated in the first stage")
+		flags.IntVar(&r.BatchSize, "batch", 0, "number of phones added per stage")
+		flags.IntVar(&r.BatchPercent, "percent", 0, "percentage of managed phones added per stage")
+		timeout := flags.Duration("timeout", 30*time.Minute, "pause the rollout if an updated phone doesn't come back in time")
+		_ = flags.Parse(args[1:])
+
+		if flags.NArg() != 1 {
+			return rolloutUsage()
+		}
+		r.Name = flags.Arg(0)
+		r.Timeout = jsonDuration(*timeout)
+
+		err = api.do(http.MethodPost, "/rollouts", r, nil)
+
+	case "advance", "pause", "resume", "delete":
+		if len(args) != 2 {
+			return rolloutUsage()
+		}
+		err = api.do(http.MethodPost, "/rollouts/"+url.PathEscape(args[1])+"/"+args[0], nil, nil)
+
+	default:
+		return rolloutUsage()
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Rollout %v failed: %v\n", args[0], err)
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zam-haus/dlsir/internal/config"
+)
+
+// Per-phone ContactMe schedule (phone config)
+const phoneContactScheduleEntry = config.LocalPrefix + "contact-schedule"
+
+const (
+	defaultContactBackoffMin = 5 * time.Minute
+	defaultContactBackoffMax = 24 * time.Hour
+)
+
+var (
+	// random delay added to every scheduled contact (contact-jitter)
+	contactJitter time.Duration
+	// minimum delay after the first failed ContactMe; it doubles with every further failure up to contact-backoff-max
+	contactBackoffMin = defaultContactBackoffMin
+	contactBackoffMax = defaultContactBackoffMax
+)
+
+// contactSchedule determines when a managed phone is contacted next
+type contactSchedule interface {
+	Next(t time.Time) time.Time
+	String() string
+}
+
+// intervalSchedule contacts the phone every Interval
+type intervalSchedule struct {
+	Interval time.Duration
+}
+
+func (s intervalSchedule) Next(t time.Time) time.Time {
+	return t.Add(s.Interval)
+}
+
+func (s intervalSchedule) String() string {
+	return "every " + s.Interval.String()
+}
+
+// parseContactSchedule parses an interval like "12h" or a cron expression like "0 3 * * *"
+func parseContactSchedule(text string) (contactSchedule, error) {
+	if interval, err := time.ParseDuration(text); err == nil {
+		if interval <= 0 {
+			return nil, fmt.Errorf("invalid interval '%v'", text)
+		}
+		return intervalSchedule{Interval: interval}, nil
+	}
+	return parseCronSchedule(text)
+}
+
+// configureContactSchedules reads contact-jitter, contact-backoff-min and contact-backoff-max
+// from the server config
+func configureContactSchedules(srvConf *config.ConfigFile) error {
+	durations := []struct {
+		name  string
+		value *time.Duration
+	}{
+		{"contact-jitter", &contactJitter},
+		{"contact-backoff-min", &contactBackoffMin},
+		{"contact-backoff-max", &contactBackoffMax},
+	}
+	for _, d := range durations {
+		entry, err := srvConf.GetEntry(d.name)
+		if err != nil {
+			continue
+		}
+		value, err := time.ParseDuration(entry.Value)
+		if err != nil || value < 0 {
+			return fmt.Errorf("invalid %v '%v'", d.name, entry.Value)
+		}
+		*d.value = value
+	}
+
+	if contactBackoffMin <= 0 || contactBackoffMax < contactBackoffMin {
+		return fmt.Errorf("contact-backoff-min must be positive and not exceed contact-backoff-max")
+	}
+
+	_, err := getContactSchedule(srvConf, nil)
+	return err
+}
+
+// getContactSchedule returns the schedule of a phone from dlsir-contact-schedule in its config,
+// contact-schedule-<group> or contact-schedule in the server config (in this order), falling back
+// to manage-interval. phoneConf is nil if the phone config is unknown.
+func getContactSchedule(srvConf *config.ConfigFile, phoneConf *config.ConfigFile) (contactSchedule, error) {
+	lookups := make([]configLookup, 0)
+	if phoneConf != nil {
+		lookups = append(lookups, configLookup{phoneConf, phoneContactScheduleEntry})
+		for _, group := range phoneConf.GetGroups() {
+			lookups = append(lookups, configLookup{srvConf, "contact-schedule-" + group})
+		}
+	}
+	lookups = append(lookups, configLookup{srvConf, "contact-schedule"}, configLookup{srvConf, "manage-interval"})
+
+	entry, ok := firstEntry(lookups)
+	if !ok {
+		return nil, fmt.Errorf("neither contact-schedule nor manage-interval is configured")
+	}
+
+	schedule, err := parseContactSchedule(entry.Value)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", entry.Name, err)
+	}
+	return schedule, nil
+}
+
+func jitter() time.Duration {
+	if contactJitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(contactJitter)))
+}
+
+// backoff returns the delay after the given number of consecutive failed contacts
+func backoff(failures int) time.Duration {
+	delay := contactBackoffMin
+	for idx := 1; idx < failures && delay < contactBackoffMax; idx++ {
+		delay *= 2
+	}
+	return min(delay, contactBackoffMax)
+}
+
+// nextContact returns the next contact of a phone after the given number of consecutive failed
+// contacts: the first contact of its schedule, but after a failure not before the backoff delay.
+// The zero time is returned if the schedule has no further contacts.
+func nextContact(schedule contactSchedule, failures int, now time.Time) time.Time {
+	next := schedule.Next(now)
+	if failures == 0 {
+		return next
+	}
+
+	earliest := now.Add(backoff(failures))
+	for !next.IsZero() && next.Before(earliest) {
+		next = schedule.Next(next)
+	}
+	return next
+}
+
+// phoneMacsByHost maps the hostname entries of all phone configs (<mac>.conf) and the IP addresses
+// these phones were last seen at (as recorded in the inventory) to the MAC address
+func phoneMacsByHost() map[string]string {
+	res := make(map[string]string)
+
+	files, err := filepath.Glob(filepath.Join(confDir, "*.conf"))
+	if err != nil {
+		return res
+	}
+
+	hostnames := make(map[string]string)
+	hasConfig := make(map[string]bool)
+	for _, file := range files {
+		mac := strings.TrimSuffix(filepath.Base(file), ".conf")
+		if !strings.Contains(mac, ":") {
+			continue
+		}
+		hasConfig[mac] = true
+
+		conf, err := config.GetConfigFile(file)
+		if err != nil {
+			continue
+		}
+		if entry, err := conf.GetEntry("hostname"); err == nil && entry.Value != "" {
+			hostnames[strings.ToLower(entry.Value)] = mac
+		}
+	}
+
+	for ip, mac := range inventory.MacsByIP() {
+		if hasConfig[mac] {
+			res[ip] = mac
+		}
+	}
+	// a configured hostname is more reliable than an address the phone was seen at
+	for host, mac := range hostnames {
+		res[host] = mac
+	}
+
+	return res
+}
+
+// scheduledPhone is a managed phone and the time of its next ContactMe
+type scheduledPhone struct {
+	Host     string
+	Mac      string // of the phone config with hostname = Host; empty if there is none
+	Schedule contactSchedule
+	Next     time.Time
+	Failures int // consecutive failed contacts
+}
+
+var contactScheduler = struct {
+	sync.Mutex
+	phones map[string]*scheduledPhone
+}{phones: make(map[string]*scheduledPhone)}
+
+// phoneSchedule reads the current schedule of a managed phone
+func phoneSchedule(srvConf *config.ConfigFile, mac string) (contactSchedule, error) {
+	if mac == "" {
+		return getContactSchedule(srvConf, nil)
+	}
+
+	device := config.Device{Type: inventory.DeviceType(mac)}
+	phoneConf, err := config.GetMergedConfig(confDir+"/"+mac+".conf", confDir+"/phonedefault.conf", device)
+	if err != nil {
+		return nil, err
+	}
+	return getContactSchedule(srvConf, phoneConf)
+}
+
+// loadSchedules sets up the schedules of the managed phones. Phones are contacted at the
+// next-contact time of the inventory if it is still ahead, otherwise right away (plus jitter).
+func loadSchedules(srvConf *config.ConfigFile, managedPhones []config.ConfigEntry) error {
+	macs := phoneMacsByHost()
+	now := time.Now()
+
+	contactScheduler.Lock()
+	defer contactScheduler.Unlock()
+
+	for _, entry := range managedPhones {
+		phone := &scheduledPhone{Host: entry.Value, Mac: macs[strings.ToLower(entry.Value)]}
+
+		schedule, err := phoneSchedule(srvConf, phone.Mac)
+		if err != nil {
+			_log(nil, "Invalid schedule of %v; using the default schedule: %v", phone.Host, err)
+			schedule, err = getContactSchedule(srvConf, nil)
+			if err != nil {
+				return err
+			}
+		}
+		phone.Schedule = schedule
+
+		phone.Next = now.Add(jitter())
+		if phone.Mac != "" {
+			next, failures := inventory.NextContact(phone.Mac)
+			if next.After(now) {
+				phone.Next = next
+				phone.Failures = failures
+			}
+		}
+
+		contactScheduler.phones[phone.Host] = phone
+	}
+
+	return nil
+}
+
+// reschedule determines the next contact after a ContactMe; err is the result of the ContactMe
+func reschedule(host string, err error) {
+	srvConf, confErr := config.GetConfigFile(confSrv)
+
+	contactScheduler.Lock()
+	defer contactScheduler.Unlock()
+
+	phone, ok := contactScheduler.phones[host]
+	if !ok {
+		return
+	}
+
+	// the config may have changed since the last contact
+	if confErr == nil {
+		schedule, err := phoneSchedule(srvConf, phone.Mac)
+		if err == nil {
+			phone.Schedule = schedule
+		} else {
+			_log(nil, "Invalid schedule of %v; keeping %v: %v", host, phone.Schedule, err)
+		}
+	}
+
+	if err == nil {
+		phone.Failures = 0
+	} else {
+		phone.Failures++
+	}
+
+	next := nextContact(phone.Schedule, phone.Failures, time.Now())
+	if next.IsZero() {
+		// the cron expression has no match within the next 5 years
+		_log(nil, "Schedule %v of %v has no further contacts; not contacting it again", phone.Schedule, host)
+		delete(contactScheduler.phones, host)
+		return
+	}
+	phone.Next = next.Add(jitter())
+
+	if err != nil {
+		_log(nil, "ContactMe to %v failed %v times in a row; next attempt at %v", host, phone.Failures, phone.Next.Format(time.DateTime))
+	}
+
+	if phone.Mac != "" {
+		inventory.ContactScheduled(phone.Mac, phone.Next, phone.Failures)
+	}
+}
+
+// dueHosts returns the hosts to contact now and the time the next phone is due
+func dueHosts(now time.Time) ([]string, time.Time) {
+	contactScheduler.Lock()
+	defer contactScheduler.Unlock()
+
+	due := make([]string, 0)
+	var next time.Time
+	for _, phone := range contactScheduler.phones {
+		if !phone.Next.After(now) {
+			due = append(due, phone.Host)
+		} else if next.IsZero() || phone.Next.Before(next) {
+			next = phone.Next
+		}
+	}
+	return due, next
+}
+
+// scheduleFunc sends ContactMe requests to the managed phones according to their schedules
+func scheduleFunc(listenPort string) {
+	for {
+		due, next := dueHosts(time.Now())
+
+		if len(due) > 0 {
+			_log(nil, "Sending ContactMe to %v scheduled phones", len(due))
+			stats := contactPhones(listenPort, due, reschedule)
+			_log(nil, "ContactMe round finished: %v", stats)
+			continue
+		}
+
+		// wake up at least every minute in case the system clock jumps
+		wait := time.Minute
+		if !next.IsZero() && time.Until(next) < wait {
+			wait = time.Until(next)
+		}
+		time.Sleep(wait)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+// withBackoff runs the test with the given backoff settings and without jitter
+func withBackoff(t *testing.T, min time.Duration, max time.Duration) {
+	t.Helper()
+
+	oldMin, oldMax, oldJitter := contactBackoffMin, contactBackoffMax, contactJitter
+	contactBackoffMin, contactBackoffMax, contactJitter = min, max, 0
+	t.Cleanup(func() { contactBackoffMin, contactBackoffMax, contactJitter = oldMin, oldMax, oldJitter })
+}
+
+// inTempDir runs the test in an empty working directory, so no config is found
+func inTempDir(t *testing.T) {
+	t.Helper()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+}
+
+func mustCron(t *testing.T, text string) *cronSchedule {
+	t.Helper()
+
+	s, err := parseCronSchedule(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestBackoff(t *testing.T) {
+	withBackoff(t, 5*time.Minute, 24*time.Hour)
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 5 * time.Minute},
+		{2, 10 * time.Minute},
+		{3, 20 * time.Minute},
+		{5, 80 * time.Minute},
+		{9, 21*time.Hour + 20*time.Minute},
+		{10, 24 * time.Hour},
+		{100, 24 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		if got := backoff(tt.failures); got != tt.want {
+			t.Errorf("backoff(%v) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestNextContact(t *testing.T) {
+	withBackoff(t, 5*time.Minute, 24*time.Hour)
+
+	now := time.Date(2024, time.May, 1, 3, 0, 0, 0, time.UTC)
+	hourly := intervalSchedule{Interval: time.Hour}
+	nightly := mustCron(t, "0 3 * * * UTC")
+	quarterly := mustCron(t, "*/15 * * * * UTC")
+
+	tests := []struct {
+		name     string
+		schedule contactSchedule
+		failures int
+		want     time.Time
+	}{
+		{"interval", hourly, 0, now.Add(time.Hour)},
+		// an unreachable phone is not contacted more often than a reachable one
+		{"interval short backoff", hourly, 1, now.Add(time.Hour)},
+		{"interval long backoff", hourly, 6, now.Add(3 * time.Hour)},
+		{"interval max backoff", hourly, 20, now.Add(24 * time.Hour)},
+		{"cron", nightly, 0, now.AddDate(0, 0, 1)},
+		// retried at the scheduled time, not during the day
+		{"cron short backoff", nightly, 1, now.AddDate(0, 0, 1)},
+		{"cron max backoff", nightly, 20, now.AddDate(0, 0, 1)},
+		{"cron backoff between matches", quarterly, 3, now.Add(30 * time.Minute)},
+		{"cron backoff on match", quarterly, 4, now.Add(45 * time.Minute)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := nextContact(tt.schedule, tt.failures, now)
+			if !got.Equal(tt.want) {
+				t.Errorf("nextContact(%v, %v) = %v, want %v", tt.schedule, tt.failures, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReschedule(t *testing.T) {
+	withBackoff(t, 5*time.Minute, 24*time.Hour)
+	inTempDir(t)
+
+	contactScheduler.Lock()
+	contactScheduler.phones["phone1"] = &scheduledPhone{Host: "phone1", Schedule: intervalSchedule{Interval: time.Hour}}
+	contactScheduler.phones["phone2"] = &scheduledPhone{Host: "phone2", Schedule: mustCron(t, "0 3 * * *")}
+	contactScheduler.Unlock()
+	t.Cleanup(func() {
+		contactScheduler.Lock()
+		delete(contactScheduler.phones, "phone1")
+		delete(contactScheduler.phones, "phone2")
+		contactScheduler.Unlock()
+	})
+
+	phone := func(host string) scheduledPhone {
+		contactScheduler.Lock()
+		defer contactScheduler.Unlock()
+		return *contactScheduler.phones[host]
+	}
+	within := func(got time.Time, want time.Time) bool {
+		return !got.Before(want.Add(-time.Minute)) && !got.After(want.Add(time.Minute))
+	}
+
+	failed := errors.New("connection refused")
+	for idx := 1; idx <= 7; idx++ {
+		reschedule("phone1", failed)
+		if p := phone("phone1"); p.Failures != idx {
+			t.Errorf("after %v failures: Failures = %v", idx, p.Failures)
+		}
+	}
+	// 7 failures: 5m * 2^6 = 5h20m
+	if p := phone("phone1"); !within(p.Next, time.Now().Add(6*time.Hour)) {
+		t.Errorf("Next after 7 failures = %v, want the first hourly contact after 5h20m", p.Next)
+	}
+
+	reschedule("phone1", nil)
+	if p := phone("phone1"); p.Failures != 0 || !within(p.Next, time.Now().Add(time.Hour)) {
+		t.Errorf("after success: Failures = %v, Next = %v, want 0 and in an hour", p.Failures, p.Next)
+	}
+
+	reschedule("phone2", failed)
+	p := phone("phone2")
+	if p.Failures != 1 || p.Next.Hour() != 3 || p.Next.Minute() != 0 {
+		t.Errorf("after failure: Failures = %v, Next = %v, want 1 and at 03:00", p.Failures, p.Next)
+	}
+
+	// unknown hosts are ignored
+	reschedule("phone3", failed)
+	contactScheduler.Lock()
+	_, ok := contactScheduler.phones["phone3"]
+	contactScheduler.Unlock()
+	if ok {
+		t.Errorf("reschedule added an unknown host")
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/zam-haus/dlsir/internal/atomicfile"
+)
+
+// Runtime state of DLSir which has to survive a restart
+const stateDir = "./state/"
+
+// jsonDuration is a time.Duration represented as string like "30m" in JSON
+type jsonDuration time.Duration
+
+func (d jsonDuration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d jsonDuration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *jsonDuration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = jsonDuration(parsed)
+	return nil
+}
+
+// writeJSONFile atomically replaces file with the JSON representation of v
+func writeJSONFile(file string, v any) error {
+	content, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.WriteFile(file, content, 0644)
+}